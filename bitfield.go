@@ -0,0 +1,35 @@
+package transmission
+
+import (
+	"encoding/base64"
+	"math/bits"
+)
+
+// Bitfield of a torrent's pieces, the highest bit of the first byte is the first piece
+type Bitfield []byte
+
+// PiecesBitfield decodes the base64 encoded pieces of the torrent
+func (t Torrent) PiecesBitfield() (Bitfield, error) {
+	return base64.StdEncoding.DecodeString(t.Pieces)
+}
+
+// Has returns true if the piece at the index is set
+func (b Bitfield) Has(index int) bool {
+	if index < 0 || index/8 >= len(b) {
+		return false
+	}
+	return b[index/8]&(0x80>>uint(index%8)) != 0
+}
+
+// Count returns the number of set pieces within the first n pieces
+func (b Bitfield) Count(n int) int {
+	var count int
+	for i := 0; i < len(b) && i*8 < n; i++ {
+		v := b[i]
+		if rest := n - i*8; rest < 8 {
+			v &= 0xff << uint(8-rest)
+		}
+		count += bits.OnesCount8(v)
+	}
+	return count
+}
@@ -0,0 +1,62 @@
+package transmission
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestBitfieldHas(t *testing.T) {
+	// Pieces 0, 3, 8 and 9 of 10
+	b := Bitfield{0x90, 0xc0}
+
+	want := map[int]bool{0: true, 3: true, 8: true, 9: true}
+	for i := -1; i < 17; i++ {
+		if got := b.Has(i); got != want[i] {
+			t.Errorf("Has(%d) = %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestBitfieldCount(t *testing.T) {
+	tests := []struct {
+		name     string
+		bitfield Bitfield
+		n        int
+		want     int
+	}{
+		{name: "empty", bitfield: nil, n: 0, want: 0},
+		{name: "empty with pieces", bitfield: nil, n: 10, want: 0},
+		{name: "full bytes", bitfield: Bitfield{0xff, 0x0f}, n: 16, want: 12},
+		// The spare bits of the final byte have to be ignored
+		{name: "partly used final byte", bitfield: Bitfield{0xff, 0xc0}, n: 10, want: 10},
+		{name: "spare bits set", bitfield: Bitfield{0xff, 0xff}, n: 10, want: 10},
+		{name: "spare bits only", bitfield: Bitfield{0x00, 0x3f}, n: 10, want: 0},
+		{name: "fewer pieces than bytes", bitfield: Bitfield{0xf0, 0xff}, n: 3, want: 3},
+		{name: "more pieces than bytes", bitfield: Bitfield{0x81}, n: 20, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.bitfield.Count(tt.n); got != tt.want {
+				t.Errorf("Count(%d) = %d, want %d", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPiecesBitfield(t *testing.T) {
+	torrent := Torrent{PieceCount: 10, Pieces: base64.StdEncoding.EncodeToString([]byte{0x90, 0xc0})}
+
+	b, err := torrent.PiecesBitfield()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Count(torrent.PieceCount); got != 4 {
+		t.Errorf("Count() = %d, want 4", got)
+	}
+
+	torrent.Pieces = "not base64!"
+	if _, err := torrent.PiecesBitfield(); err == nil {
+		t.Error("expected an error decoding invalid pieces")
+	}
+}
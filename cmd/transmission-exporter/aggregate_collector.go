@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+
+	transmission "github.com/metalmatze/transmission-exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// aggregateDescs are the metrics exported for every group of torrents
+type aggregateDescs struct {
+	Torrents     *prometheus.Desc
+	Size         *prometheus.Desc
+	Left         *prometheus.Desc
+	Download     *renamedMetric
+	Upload       *renamedMetric
+	UploadedEver *prometheus.Desc
+	Ratio        *prometheus.Desc
+}
+
+func newAggregateDescs(naming Naming, group, label string) aggregateDescs {
+	return aggregateDescs{
+		Torrents: prometheus.NewDesc(
+			namespace+group+"_torrents",
+			"The number of torrents by status",
+			[]string{label, "status"},
+			nil,
+		),
+		Size: prometheus.NewDesc(
+			namespace+group+"_size_bytes",
+			"The total size of the torrents",
+			[]string{label},
+			nil,
+		),
+		Left: prometheus.NewDesc(
+			namespace+group+"_left_bytes",
+			"The number of bytes left until the torrents are done",
+			[]string{label},
+			nil,
+		),
+		Download: newRenamedMetric(
+			naming,
+			namespace+group+"_download_bytes",
+			namespace+group+"_download_bytes_per_second",
+			prometheus.GaugeValue,
+			"The current download rate of the torrents in bytes",
+			[]string{label},
+		),
+		Upload: newRenamedMetric(
+			naming,
+			namespace+group+"_upload_bytes",
+			namespace+group+"_upload_bytes_per_second",
+			prometheus.GaugeValue,
+			"The current upload rate of the torrents in bytes",
+			[]string{label},
+		),
+		UploadedEver: prometheus.NewDesc(
+			namespace+group+"_uploaded_ever_bytes",
+			"The total uploaded bytes of the torrents",
+			[]string{label},
+			nil,
+		),
+		Ratio: prometheus.NewDesc(
+			namespace+group+"_ratio_average",
+			"The average upload ratio of the torrents",
+			[]string{label},
+			nil,
+		),
+	}
+}
+
+func (d aggregateDescs) describe(ch chan<- *prometheus.Desc) {
+	ch <- d.Torrents
+	ch <- d.Size
+	ch <- d.Left
+	d.Download.describe(ch)
+	d.Upload.describe(ch)
+	ch <- d.UploadedEver
+	ch <- d.Ratio
+}
+
+// torrentAggregate sums up the torrents sharing the same label value
+type torrentAggregate struct {
+	Statuses     map[int]int
+	Size         int64
+	Left         int64
+	Download     int64
+	Upload       int64
+	UploadedEver int64
+	RatioSum     float64
+	RatioCount   int
+}
+
+// torrentAggregates maps label values to the summed up torrentAggregate
+type torrentAggregates map[string]*torrentAggregate
+
+func (a torrentAggregates) add(value string, t transmission.Torrent) {
+	agg, ok := a[value]
+	if !ok {
+		agg = &torrentAggregate{Statuses: make(map[int]int)}
+		a[value] = agg
+	}
+
+	agg.Statuses[t.Status]++
+	agg.Size += int64(t.TotalSize)
+	agg.Left += t.LeftUntilDone
+	agg.Download += int64(t.RateDownload)
+	agg.Upload += int64(t.RateUpload)
+	agg.UploadedEver += int64(t.UploadedEver)
+
+	// Transmission reports negative ratios if there's nothing to compare with yet
+	if t.UploadRatio >= 0 {
+		agg.RatioSum += t.UploadRatio
+		agg.RatioCount++
+	}
+}
+
+func (a torrentAggregates) collect(ch chan<- prometheus.Metric, d aggregateDescs) {
+	for value, agg := range a {
+		for status, name := range torrentStatuses {
+			ch <- prometheus.MustNewConstMetric(
+				d.Torrents,
+				prometheus.GaugeValue,
+				float64(agg.Statuses[status]),
+				value, name,
+			)
+		}
+
+		var ratio float64
+		if agg.RatioCount > 0 {
+			ratio = agg.RatioSum / float64(agg.RatioCount)
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			d.Size,
+			prometheus.GaugeValue,
+			float64(agg.Size),
+			value,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			d.Left,
+			prometheus.GaugeValue,
+			float64(agg.Left),
+			value,
+		)
+		d.Download.collect(ch, float64(agg.Download), value)
+		d.Upload.collect(ch, float64(agg.Upload), value)
+		ch <- prometheus.MustNewConstMetric(
+			d.UploadedEver,
+			prometheus.GaugeValue,
+			float64(agg.UploadedEver),
+			value,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			d.Ratio,
+			prometheus.GaugeValue,
+			ratio,
+			value,
+		)
+	}
+}
+
+// AggregateCollector exposes metrics of torrents aggregated by
+// download directory and tracker host, independent of per-torrent metrics
+type AggregateCollector struct {
+	filter *TorrentFilter
+
+	DownloadDir aggregateDescs
+	Tracker     aggregateDescs
+}
+
+// NewAggregateCollector creates a new aggregate collector
+func NewAggregateCollector(filter *TorrentFilter, naming Naming) *AggregateCollector {
+	return &AggregateCollector{
+		filter: filter,
+
+		DownloadDir: newAggregateDescs(naming, "download_dir", "download_dir"),
+		Tracker:     newAggregateDescs(naming, "tracker", "tracker"),
+	}
+}
+
+// Describe implements the prometheus.Collector interface
+func (ac *AggregateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ac.DownloadDir.describe(ch)
+	ac.Tracker.describe(ch)
+}
+
+// RPCs implements the collector interface
+func (ac *AggregateCollector) RPCs() []string {
+	return []string{torrentGet}
+}
+
+// Update implements the collector interface
+func (ac *AggregateCollector) Update(ch chan<- prometheus.Metric, s *snapshot) error {
+	if err := s.err(torrentGet); err != nil {
+		return fmt.Errorf("failed to get torrents: %v", err)
+	}
+	torrents := s.Torrents
+
+	dirs := torrentAggregates{}
+	trackers := torrentAggregates{}
+
+	for _, t := range torrents {
+		if !ac.filter.MatchAggregate(t) {
+			continue
+		}
+
+		dirs.add(sanitizeLabel(t.DownloadDir, 0), t)
+
+		// Count every torrent only once per host, even with multiple tiers
+		hosts := make(map[string]bool)
+		for _, tracker := range t.TrackerStats {
+			host := sanitizeLabel(tracker.Host, 0)
+			if hosts[host] {
+				continue
+			}
+			hosts[host] = true
+			trackers.add(host, t)
+		}
+	}
+
+	dirs.collect(ch, ac.DownloadDir)
+	trackers.collect(ch, ac.Tracker)
+
+	return nil
+}
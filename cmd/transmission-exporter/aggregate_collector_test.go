@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	transmission "github.com/metalmatze/transmission-exporter"
+)
+
+func TestAggregateCollectorLabels(t *testing.T) {
+	torrents := []transmission.Torrent{
+		{
+			ID:          1,
+			DownloadDir: "/downloads/\nlinux",
+			TotalSize:   100,
+			TrackerStats: []transmission.TrackerStat{
+				{Host: "tracker.example.org:443", Tier: 0},
+				{Host: "tracker.example.org:443", Tier: 1},
+			},
+		},
+		{
+			ID:          2,
+			DownloadDir: "/downloads/ linux",
+			TotalSize:   50,
+			TrackerStats: []transmission.TrackerStat{
+				{Host: "tracker.example.org:443\x00"},
+			},
+		},
+	}
+
+	// gather fails on invalid UTF-8 and series with the same labels
+	families := gather(t, &fakeSource{torrents: torrents}, map[string]collector{
+		"aggregate": NewAggregateCollector(nil, NamingLegacy),
+	})
+
+	tests := []struct {
+		metric string
+		labels map[string]string
+		want   float64
+	}{
+		{"transmission_download_dir_size_bytes", map[string]string{"download_dir": "/downloads/ linux"}, 150},
+		{"transmission_tracker_size_bytes", map[string]string{"tracker": "tracker.example.org:443"}, 150},
+		{"transmission_tracker_torrents", map[string]string{"tracker": "tracker.example.org:443", "status": "stopped"}, 2},
+	}
+	for _, tt := range tests {
+		if got, ok := metricValue(families[tt.metric], tt.labels); !ok || got != tt.want {
+			t.Errorf("%s%v = %v, want %v", tt.metric, tt.labels, got, tt.want)
+		}
+	}
+	for _, metric := range []string{"transmission_download_dir_size_bytes", "transmission_tracker_size_bytes"} {
+		if n := len(families[metric].GetMetric()); n != 1 {
+			t.Errorf("%s has %d series, want 1", metric, n)
+		}
+	}
+}
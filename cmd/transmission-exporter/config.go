@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// FileConfig is the content of the YAML or TOML config file.
+// Settings of the file are overridden by env vars, which are overridden by flags.
+type FileConfig struct {
+	Transmission    TransmissionConfig `yaml:"transmission" toml:"transmission"`
+	Web             WebConfig          `yaml:"web" toml:"web"`
+	GeoIP           GeoIPConfig        `yaml:"geoip" toml:"geoip"`
+	Torrents        TorrentsConfig     `yaml:"torrents" toml:"torrents"`
+	MetricsNaming   *string            `yaml:"metrics_naming" toml:"metrics_naming"`
+	PollInterval    *time.Duration     `yaml:"poll_interval" toml:"poll_interval"`
+	ScrapeTimeout   *time.Duration     `yaml:"scrape_timeout" toml:"scrape_timeout"`
+	ShutdownTimeout *time.Duration     `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	Log             LogConfig          `yaml:"log" toml:"log"`
+	Collectors      CollectorsConfig   `yaml:"collectors" toml:"collectors"`
+	Push            PushConfig         `yaml:"push" toml:"push"`
+
+	TorrentFilter TorrentFilter         `yaml:"torrent_filter" toml:"torrent_filter"`
+	AuthModules   map[string]AuthModule `yaml:"auth_modules" toml:"auth_modules"`
+	Instances     []Instance            `yaml:"instances" toml:"instances"`
+}
+
+// TransmissionConfig configures the Transmission instance to scrape
+type TransmissionConfig struct {
+	Address  *string `yaml:"address" toml:"address"`
+	Username *string `yaml:"username" toml:"username"`
+	Password *string `yaml:"password" toml:"password"`
+
+	UsernameFile *string `yaml:"username_file" toml:"username_file"`
+	PasswordFile *string `yaml:"password_file" toml:"password_file"`
+}
+
+// WebConfig configures the exporter's web server
+type WebConfig struct {
+	ListenAddress *string `yaml:"listen_address" toml:"listen_address"`
+	TelemetryPath *string `yaml:"telemetry_path" toml:"telemetry_path"`
+	ConfigFile    *string `yaml:"config_file" toml:"config_file"`
+}
+
+// LogConfig configures the exporter's logs
+type LogConfig struct {
+	Level  *string `yaml:"level" toml:"level"`
+	Format *string `yaml:"format" toml:"format"`
+}
+
+// CollectorsConfig enables or disables the collectors
+type CollectorsConfig struct {
+	Torrent      *bool `yaml:"torrent" toml:"torrent"`
+	Session      *bool `yaml:"session" toml:"session"`
+	SessionStats *bool `yaml:"session_stats" toml:"session_stats"`
+	Peer         *bool `yaml:"peer" toml:"peer"`
+	Aggregate    *bool `yaml:"aggregate" toml:"aggregate"`
+}
+
+// PushConfig configures pushing the metrics to a Pushgateway, remote-write or OTLP endpoint
+type PushConfig struct {
+	Interval        *time.Duration    `yaml:"interval" toml:"interval"`
+	PushgatewayURL  *string           `yaml:"pushgateway_url" toml:"pushgateway_url"`
+	RemoteWriteURL  *string           `yaml:"remote_write_url" toml:"remote_write_url"`
+	OTLPURL         *string           `yaml:"otlp_url" toml:"otlp_url"`
+	Job             *string           `yaml:"job" toml:"job"`
+	Grouping        map[string]string `yaml:"grouping" toml:"grouping"`
+	Username        *string           `yaml:"username" toml:"username"`
+	Password        *string           `yaml:"password" toml:"password"`
+	BearerToken     *string           `yaml:"bearer_token" toml:"bearer_token"`
+	BearerTokenFile *string           `yaml:"bearer_token_file" toml:"bearer_token_file"`
+}
+
+// GeoIPConfig configures the GeoIP databases of the peer metrics
+type GeoIPConfig struct {
+	CountryDB *string `yaml:"country_db" toml:"country_db"`
+	ASNDB     *string `yaml:"asn_db" toml:"asn_db"`
+	TopN      *int    `yaml:"top_n" toml:"top_n"`
+}
+
+// TorrentsConfig configures the per-torrent metrics
+type TorrentsConfig struct {
+	Labels       []string `yaml:"labels" toml:"labels"`
+	NameLength   *int     `yaml:"name_length" toml:"name_length"`
+	MaxSeries    *int     `yaml:"max_series" toml:"max_series"`
+	TopN         *int     `yaml:"top_n" toml:"top_n"`
+	TopBy        *string  `yaml:"top_by" toml:"top_by"`
+	FileMetrics  *bool    `yaml:"file_metrics" toml:"file_metrics"`
+	PieceMetrics *bool    `yaml:"piece_metrics" toml:"piece_metrics"`
+	ErrorLength  *int     `yaml:"error_length" toml:"error_length"`
+}
+
+// configFilePath returns the path passed with --config.file or CONFIG_FILE,
+// so the config file can be applied before the flags are parsed
+func configFilePath(args []string) string {
+	for i, a := range args {
+		if a == "--" {
+			break
+		}
+		if a == "--config.file" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(a, "--config.file=") {
+			return strings.TrimPrefix(a, "--config.file=")
+		}
+	}
+	return os.Getenv("CONFIG_FILE")
+}
+
+// loadConfigFile reads the config file at path, files ending in .toml are
+// parsed as TOML, all others as YAML. Unknown keys are rejected.
+func loadConfigFile(path string) (*FileConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fc FileConfig
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		md, err := toml.NewDecoder(bytes.NewReader(content)).Decode(&fc)
+		if err != nil {
+			return nil, err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, 0, len(undecoded))
+			for _, k := range undecoded {
+				keys = append(keys, k.String())
+			}
+			sort.Strings(keys)
+			return nil, fmt.Errorf("unknown keys %s", strings.Join(keys, ", "))
+		}
+	} else {
+		if err := yaml.UnmarshalStrict(content, &fc); err != nil {
+			return nil, err
+		}
+	}
+
+	return &fc, nil
+}
+
+// apply sets the settings of the config file on c
+func (fc *FileConfig) apply(c *Config) {
+	setString(&c.TransmissionAddr, fc.Transmission.Address)
+	setString(&c.TransmissionUsername, fc.Transmission.Username)
+	setString(&c.TransmissionPassword, fc.Transmission.Password)
+	setString(&c.TransmissionUsernameFile, fc.Transmission.UsernameFile)
+	setString(&c.TransmissionPasswordFile, fc.Transmission.PasswordFile)
+	setString(&c.WebAddr, fc.Web.ListenAddress)
+	setString(&c.WebPath, fc.Web.TelemetryPath)
+	setString(&c.WebConfigFile, fc.Web.ConfigFile)
+	setString(&c.GeoIPCountryDB, fc.GeoIP.CountryDB)
+	setString(&c.GeoIPASNDB, fc.GeoIP.ASNDB)
+	setInt(&c.GeoIPTopN, fc.GeoIP.TopN)
+	if len(fc.Torrents.Labels) > 0 {
+		c.TorrentLabels = strings.Join(fc.Torrents.Labels, ",")
+	}
+	setInt(&c.TorrentNameLength, fc.Torrents.NameLength)
+	setInt(&c.TorrentMaxSeries, fc.Torrents.MaxSeries)
+	setInt(&c.TorrentTopN, fc.Torrents.TopN)
+	setString(&c.TorrentTopBy, fc.Torrents.TopBy)
+	setBool(&c.TorrentFileMetrics, fc.Torrents.FileMetrics)
+	setBool(&c.TorrentPieceMetrics, fc.Torrents.PieceMetrics)
+	setInt(&c.TorrentErrorLength, fc.Torrents.ErrorLength)
+	setString(&c.MetricsNaming, fc.MetricsNaming)
+	if fc.PollInterval != nil {
+		c.PollInterval = *fc.PollInterval
+	}
+	if fc.ScrapeTimeout != nil {
+		c.ScrapeTimeout = *fc.ScrapeTimeout
+	}
+	if fc.ShutdownTimeout != nil {
+		c.ShutdownTimeout = *fc.ShutdownTimeout
+	}
+	setString(&c.LogLevel, fc.Log.Level)
+	setString(&c.LogFormat, fc.Log.Format)
+	setBool(&c.CollectorTorrent, fc.Collectors.Torrent)
+	setBool(&c.CollectorSession, fc.Collectors.Session)
+	setBool(&c.CollectorSessionStats, fc.Collectors.SessionStats)
+	setBool(&c.CollectorPeer, fc.Collectors.Peer)
+	setBool(&c.CollectorAggregate, fc.Collectors.Aggregate)
+	if fc.Push.Interval != nil {
+		c.PushInterval = *fc.Push.Interval
+	}
+	setString(&c.PushPushgatewayURL, fc.Push.PushgatewayURL)
+	setString(&c.PushRemoteWriteURL, fc.Push.RemoteWriteURL)
+	setString(&c.PushOTLPURL, fc.Push.OTLPURL)
+	setString(&c.PushJob, fc.Push.Job)
+	setString(&c.PushUsername, fc.Push.Username)
+	setString(&c.PushPassword, fc.Push.Password)
+	setString(&c.PushBearerToken, fc.Push.BearerToken)
+	setString(&c.PushBearerTokenFile, fc.Push.BearerTokenFile)
+}
+
+func setString(dst *string, v *string) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+func setInt(dst *int, v *int) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+func setBool(dst *bool, v *bool) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+// torrentFilter merges the filter flags into the filter of the config file
+func (c Config) torrentFilter(fc *FileConfig) (*TorrentFilter, error) {
+	var f TorrentFilter
+	if fc != nil {
+		f = fc.TorrentFilter
+	}
+
+	// Regular expressions contain commas, so the flags take a single one each
+	if c.TorrentIncludeName != "" {
+		f.Include.Names = append(f.Include.Names, c.TorrentIncludeName)
+	}
+	if c.TorrentExcludeName != "" {
+		f.Exclude.Names = append(f.Exclude.Names, c.TorrentExcludeName)
+	}
+	f.Include.DownloadDirs = append(f.Include.DownloadDirs, c.TorrentIncludeDir...)
+	f.Exclude.DownloadDirs = append(f.Exclude.DownloadDirs, c.TorrentExcludeDir...)
+	f.Include.Statuses = append(f.Include.Statuses, c.TorrentIncludeStatus...)
+	f.Exclude.Statuses = append(f.Exclude.Statuses, c.TorrentExcludeStatus...)
+	f.Include.Trackers = append(f.Include.Trackers, c.TorrentIncludeTracker...)
+	f.Exclude.Trackers = append(f.Exclude.Trackers, c.TorrentExcludeTracker...)
+	f.Include.Labels = append(f.Include.Labels, c.TorrentIncludeLabel...)
+	f.Exclude.Labels = append(f.Exclude.Labels, c.TorrentExcludeLabel...)
+
+	if c.TorrentMinSize > 0 {
+		f.MinSize = c.TorrentMinSize
+	}
+	if c.TorrentFilterAggregates {
+		f.ApplyToAggregates = true
+	}
+
+	if err := f.Compile(); err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// pushOptions merges the grouping flags into the grouping of the config file,
+// flags take precedence for the same label
+func (c Config) pushOptions(fc *FileConfig) (pushOptions, error) {
+	opts := pushOptions{
+		Interval:        c.PushInterval,
+		PushgatewayURL:  c.PushPushgatewayURL,
+		RemoteWriteURL:  c.PushRemoteWriteURL,
+		OTLPURL:         c.PushOTLPURL,
+		Job:             c.PushJob,
+		Grouping:        make(map[string]string),
+		Username:        c.PushUsername,
+		Password:        c.PushPassword,
+		BearerToken:     c.PushBearerToken,
+		BearerTokenFile: c.PushBearerTokenFile,
+	}
+
+	if fc != nil {
+		pairs := make([]string, 0, len(fc.Push.Grouping))
+		for k, v := range fc.Push.Grouping {
+			pairs = append(pairs, k+"="+v)
+		}
+		grouping, err := parseGrouping(pairs)
+		if err != nil {
+			return opts, err
+		}
+		opts.Grouping = grouping
+	}
+
+	grouping, err := parseGrouping(c.PushGrouping)
+	if err != nil {
+		return opts, err
+	}
+	for k, v := range grouping {
+		opts.Grouping[k] = v
+	}
+
+	if opts.enabled() && opts.Interval <= 0 {
+		return opts, fmt.Errorf("push interval must be positive")
+	}
+	if opts.PushgatewayURL != "" && opts.Job == "" {
+		return opts, fmt.Errorf("pushing to a pushgateway needs a job")
+	}
+	if opts.BearerToken != "" && opts.BearerTokenFile != "" {
+		return opts, fmt.Errorf("push bearer token and bearer token file are mutually exclusive")
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	arg "github.com/alexflint/go-arg"
+	transmission "github.com/metalmatze/transmission-exporter"
+)
+
+func TestConfigFilePath(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  string
+		want string
+	}{
+		{name: "none"},
+		{name: "env", env: "/etc/env.yml", want: "/etc/env.yml"},
+		{name: "flag", args: []string{"--log.level", "debug", "--config.file", "/etc/flag.yml"}, env: "/etc/env.yml", want: "/etc/flag.yml"},
+		{name: "flag with equals", args: []string{"--config.file=/etc/flag.toml"}, want: "/etc/flag.toml"},
+		{name: "flag without value", args: []string{"--config.file"}, env: "/etc/env.yml", want: "/etc/env.yml"},
+		{name: "after double dash", args: []string{"--", "--config.file", "/etc/flag.yml"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CONFIG_FILE", tt.env)
+			if got := configFilePath(tt.args); got != tt.want {
+				t.Errorf("configFilePath(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTorrentFilterNameRegexp(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  string
+	}{
+		{name: "flag", args: []string{"--torrent.include-name", "^x{1,3}$"}},
+		{name: "env", env: "^x{1,3}$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TORRENT_INCLUDE_NAME", tt.env)
+
+			var c Config
+			p, err := arg.NewParser(arg.Config{}, &c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			// The regular expression with a comma is kept as a whole
+			f, err := c.torrentFilter(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !f.Match(transmission.Torrent{Name: "xxx"}) {
+				t.Error("xxx doesn't match ^x{1,3}$")
+			}
+			if f.Match(transmission.Torrent{Name: "xxxx"}) {
+				t.Error("xxxx matches ^x{1,3}$")
+			}
+		})
+	}
+}
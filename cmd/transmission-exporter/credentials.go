@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	transmission "github.com/metalmatze/transmission-exporter"
+)
+
+// credentials of a Transmission instance, either given directly
+// or read from files like Docker and Kubernetes secrets
+type credentials struct {
+	Username     string
+	Password     string
+	UsernameFile string
+	PasswordFile string
+}
+
+// hasFiles returns true if any of the credentials is read from a file
+func (c credentials) hasFiles() bool {
+	return c.UsernameFile != "" || c.PasswordFile != ""
+}
+
+// user returns the transmission.User with the current content of the files,
+// nil unless there's a username and a password
+func (c credentials) user() (*transmission.User, error) {
+	username, password := c.Username, c.Password
+
+	if c.UsernameFile != "" {
+		content, err := ioutil.ReadFile(c.UsernameFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read username file: %v", err)
+		}
+		username = strings.TrimRight(string(content), "\r\n")
+	}
+	if c.PasswordFile != "" {
+		content, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read password file: %v", err)
+		}
+		password = strings.TrimRight(string(content), "\r\n")
+	}
+
+	if username == "" || password == "" {
+		return nil, nil
+	}
+	return &transmission.User{Username: username, Password: password}, nil
+}
+
+// checkCredentials reads the credential files of the Transmission instance,
+// or of the instances and auth modules of the config file if it has any
+func checkCredentials(creds credentials, fc *FileConfig) error {
+	if fc == nil {
+		_, err := creds.user()
+		return err
+	}
+
+	if len(fc.Instances) == 0 {
+		if _, err := creds.user(); err != nil {
+			return err
+		}
+	}
+	for _, i := range fc.Instances {
+		if _, err := i.credentials().user(); err != nil {
+			return fmt.Errorf("instance %q: %v", i.Name, err)
+		}
+	}
+
+	names := make([]string, 0, len(fc.AuthModules))
+	for name := range fc.AuthModules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, err := fc.AuthModules[name].credentials().user(); err != nil {
+			return fmt.Errorf("auth module %q: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// client creates a transmission.Client for the address, which re-reads
+// the credential files if Transmission rejects them to pick up rotated secrets
+func (c credentials) client(address string) (*transmission.Client, error) {
+	user, err := c.user()
+	if err != nil {
+		return nil, err
+	}
+
+	client := transmission.New(address, user)
+	if c.hasFiles() {
+		client.RefreshUser = c.user
+	}
+
+	return client, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCredentialsUser(t *testing.T) {
+	dir := t.TempDir()
+	usernameFile := filepath.Join(dir, "username")
+	if err := os.WriteFile(usernameFile, []byte("bob\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		creds credentials
+		want  string
+		nil   bool
+		err   bool
+	}{
+		{name: "none", nil: true},
+		{name: "username only", creds: credentials{Username: "bob"}, nil: true},
+		{name: "password only", creds: credentials{Password: "secret"}, nil: true},
+		{name: "both", creds: credentials{Username: "bob", Password: "secret"}, want: "bob"},
+		{name: "username file", creds: credentials{UsernameFile: usernameFile, Password: "secret"}, want: "bob"},
+		{name: "missing file", creds: credentials{UsernameFile: filepath.Join(dir, "missing"), Password: "secret"}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := tt.creds.user()
+			if tt.err {
+				if err == nil {
+					t.Error("expected an error reading the credentials")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.nil {
+				if user != nil {
+					t.Errorf("got user %+v, want none", user)
+				}
+				return
+			}
+			if user == nil || user.Username != tt.want {
+				t.Errorf("got user %+v, want %q", user, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckCredentials(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	tests := []struct {
+		name  string
+		creds credentials
+		fc    *FileConfig
+		valid bool
+	}{
+		{name: "no files", creds: credentials{Username: "bob", Password: "secret"}, valid: true},
+		{name: "missing file", creds: credentials{PasswordFile: missing}},
+		{
+			name:  "instances replace the transmission credentials",
+			creds: credentials{PasswordFile: missing},
+			fc:    &FileConfig{Instances: []Instance{{Name: "nas", Address: "http://nas:9091"}}},
+			valid: true,
+		},
+		{
+			name: "missing instance file",
+			fc:   &FileConfig{Instances: []Instance{{Name: "nas", Address: "http://nas:9091", PasswordFile: missing}}},
+		},
+		{
+			name: "missing auth module file",
+			fc:   &FileConfig{AuthModules: map[string]AuthModule{"default": {PasswordFile: missing}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkCredentials(tt.creds, tt.fc); (err == nil) != tt.valid {
+				t.Errorf("checkCredentials() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
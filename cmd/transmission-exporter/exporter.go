@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	transmission "github.com/metalmatze/transmission-exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Set at build time with -ldflags
+var (
+	version  = "dev"
+	revision = "unknown"
+)
+
+// collector is implemented by every collector the Exporter runs
+type collector interface {
+	// Describe sends the descriptors of all metrics the collector can export
+	Describe(ch chan<- *prometheus.Desc)
+	// RPCs returns the methods of the snapshot the collector needs
+	RPCs() []string
+	// Update sends the metrics of the snapshot
+	// and returns an error if the RPCs it needs failed
+	Update(ch chan<- prometheus.Metric, s *snapshot) error
+}
+
+// Exporter fetches a snapshot from the source on every scrape,
+// runs its collectors concurrently on it and exposes metrics about the scrape itself
+type Exporter struct {
+	logger     log.Logger
+	source     source
+	timeout    time.Duration
+	collectors map[string]collector
+	// rpcs are the methods needed by the collectors
+	rpcs []string
+
+	Up             *prometheus.Desc
+	FetchDuration  *prometheus.Desc
+	FetchSuccess   *prometheus.Desc
+	ScrapeDuration *prometheus.Desc
+	ScrapeSuccess  *prometheus.Desc
+}
+
+// NewExporter creates a new Exporter running the named collectors,
+// all RPCs of a scrape share the timeout, 0 disables it
+func NewExporter(logger log.Logger, src source, timeout time.Duration, collectors map[string]collector) *Exporter {
+	const collectorNamespace = "exporter_"
+
+	return &Exporter{
+		logger:     logger,
+		source:     src,
+		timeout:    timeout,
+		collectors: collectors,
+		rpcs:       collectorRPCs(collectors),
+
+		Up: prometheus.NewDesc(
+			namespace+"up",
+			"Indicates if Transmission could be reached (1) or not (0) during the scrape",
+			nil,
+			nil,
+		),
+		FetchDuration: prometheus.NewDesc(
+			namespace+collectorNamespace+"fetch_duration_seconds",
+			"The duration of an RPC fetching the scrape's snapshot",
+			[]string{"method"},
+			nil,
+		),
+		FetchSuccess: prometheus.NewDesc(
+			namespace+collectorNamespace+"fetch_success",
+			"Indicates if an RPC fetching the scrape's snapshot succeeded (1) or not (0)",
+			[]string{"method"},
+			nil,
+		),
+		ScrapeDuration: prometheus.NewDesc(
+			namespace+collectorNamespace+"scrape_duration_seconds",
+			"The duration of a collector's scrape",
+			[]string{"collector"},
+			nil,
+		),
+		ScrapeSuccess: prometheus.NewDesc(
+			namespace+collectorNamespace+"scrape_success",
+			"Indicates if a collector's scrape succeeded (1) or not (0)",
+			[]string{"collector"},
+			nil,
+		),
+	}
+}
+
+// collectorRPCs returns the methods needed by any of the collectors
+func collectorRPCs(collectors map[string]collector) []string {
+	needed := make(map[string]bool)
+	for _, c := range collectors {
+		for _, method := range c.RPCs() {
+			needed[method] = true
+		}
+	}
+
+	var rpcs []string
+	for _, method := range allRPCs {
+		if needed[method] {
+			rpcs = append(rpcs, method)
+		}
+	}
+	return rpcs
+}
+
+// view returns an Exporter only running the named collectors and fetching the RPCs they need,
+// all collectors if names is empty. Hyphens in names are treated like underscores.
+func (e *Exporter) view(names []string) (*Exporter, error) {
+	if len(names) == 0 {
+		return e, nil
+	}
+
+	collectors := make(map[string]collector, len(names))
+	for _, name := range names {
+		name = strings.Replace(name, "-", "_", -1)
+		c, ok := e.collectors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or disabled collector %q, enabled collectors are %s", name, strings.Join(e.collectorNames(), ", "))
+		}
+		collectors[name] = c
+	}
+
+	v := *e
+	v.collectors = collectors
+	v.rpcs = collectorRPCs(collectors)
+	return &v, nil
+}
+
+// collectorNames returns the sorted names of the Exporter's collectors
+func (e *Exporter) collectorNames() []string {
+	names := make([]string, 0, len(e.collectors))
+	for name := range e.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Describe implements the prometheus.Collector interface
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- e.Up
+	ch <- e.FetchDuration
+	ch <- e.FetchSuccess
+	ch <- e.ScrapeDuration
+	ch <- e.ScrapeSuccess
+
+	for _, c := range e.collectors {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements the prometheus.Collector interface
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	s := fetchSnapshot(e.source, e.timeout, e.rpcs)
+
+	// Transmission is up as long as any RPC succeeded
+	up := false
+	for method, f := range s.Fetches {
+		if f.Err != nil {
+			level.Error(e.logger).Log("msg", "failed to fetch snapshot", "method", method, "duration", f.Duration, "err", redact(f.Err.Error()))
+		} else {
+			up = true
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			e.FetchDuration,
+			prometheus.GaugeValue,
+			f.Duration.Seconds(),
+			method,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			e.FetchSuccess,
+			prometheus.GaugeValue,
+			boolToFloat(f.Err == nil),
+			method,
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		e.Up,
+		prometheus.GaugeValue,
+		boolToFloat(up),
+	)
+
+	var wg sync.WaitGroup
+	wg.Add(len(e.collectors))
+	for name, c := range e.collectors {
+		go func(name string, c collector) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := c.Update(ch, s)
+			duration := time.Since(start)
+
+			success := err == nil
+			if err != nil {
+				level.Error(e.logger).Log("msg", "collector failed", "collector", name, "duration", duration, "err", redact(err.Error()))
+			}
+
+			ch <- prometheus.MustNewConstMetric(
+				e.ScrapeDuration,
+				prometheus.GaugeValue,
+				duration.Seconds(),
+				name,
+			)
+			ch <- prometheus.MustNewConstMetric(
+				e.ScrapeSuccess,
+				prometheus.GaugeValue,
+				boolToFloat(success),
+				name,
+			)
+		}(name, c)
+	}
+	wg.Wait()
+}
+
+// rpcMetrics observes the RPC requests of a transmission.Client
+type rpcMetrics struct {
+	Requests *prometheus.CounterVec
+	Duration *prometheus.HistogramVec
+}
+
+func newRPCMetrics() *rpcMetrics {
+	const collectorNamespace = "exporter_"
+
+	return &rpcMetrics{
+		Requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: namespace + collectorNamespace + "rpc_requests_total",
+				Help: "The number of RPC requests sent to Transmission",
+			},
+			[]string{"method", "outcome"},
+		),
+		Duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    namespace + collectorNamespace + "rpc_duration_seconds",
+				Help:    "The duration of RPC requests sent to Transmission",
+				Buckets: []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+			},
+			[]string{"method", "outcome"},
+		),
+	}
+}
+
+// Describe implements the prometheus.Collector interface
+func (m *rpcMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.Requests.Describe(ch)
+	m.Duration.Describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface
+func (m *rpcMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.Requests.Collect(ch)
+	m.Duration.Collect(ch)
+}
+
+// Observe is meant to be used as transmission.Client.OnRequest
+func (m *rpcMetrics) Observe(info transmission.RequestInfo) {
+	outcome := rpcOutcome(info)
+	m.Requests.WithLabelValues(info.Method, outcome).Inc()
+	m.Duration.WithLabelValues(info.Method, outcome).Observe(info.Duration.Seconds())
+}
+
+// rpcOutcome classifies a request into success, unauthorized,
+// network_error if Transmission couldn't be reached at all, or error
+func rpcOutcome(info transmission.RequestInfo) string {
+	switch {
+	case info.Err == nil:
+		return "success"
+	case info.StatusCode == http.StatusUnauthorized:
+		return "unauthorized"
+	case info.StatusCode == 0:
+		return "network_error"
+	default:
+		return "error"
+	}
+}
+
+// newBuildInfo returns a gauge with the exporter's build information as labels
+func newBuildInfo() prometheus.Collector {
+	buildInfo := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: namespace + "exporter_build_info",
+			Help: "A metric with a constant '1' value labeled by version, revision and goversion of the exporter",
+		},
+		[]string{"version", "revision", "goversion"},
+	)
+	buildInfo.WithLabelValues(version, revision, runtime.Version()).Set(1)
+
+	return buildInfo
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-kit/log"
+	transmission "github.com/metalmatze/transmission-exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// fakeSource serves fixed data, or err for every RPC if set
+type fakeSource struct {
+	torrents     []transmission.Torrent
+	session      transmission.Session
+	sessionStats transmission.SessionStats
+	err          error
+}
+
+func (s *fakeSource) GetTorrents(ctx context.Context) ([]transmission.Torrent, error) {
+	return s.torrents, s.err
+}
+
+func (s *fakeSource) GetSession(ctx context.Context) (*transmission.Session, error) {
+	return &s.session, s.err
+}
+
+func (s *fakeSource) GetSessionStats(ctx context.Context) (*transmission.SessionStats, error) {
+	return &s.sessionStats, s.err
+}
+
+// gather scrapes the collectors from the source and returns the metric families by name
+func gather(t *testing.T, src source, collectors map[string]collector) map[string]*dto.MetricFamily {
+	t.Helper()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewExporter(log.NewNopLogger(), src, 0, collectors))
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byName := make(map[string]*dto.MetricFamily, len(families))
+	for _, mf := range families {
+		byName[mf.GetName()] = mf
+	}
+	return byName
+}
+
+// metricValue returns the value of the gauge or counter with the labels
+func metricValue(mf *dto.MetricFamily, labels map[string]string) (float64, bool) {
+metrics:
+	for _, m := range mf.GetMetric() {
+		for _, l := range m.GetLabel() {
+			if v, ok := labels[l.GetName()]; ok && v != l.GetValue() {
+				continue metrics
+			}
+		}
+		if m.GetCounter() != nil {
+			return m.GetCounter().GetValue(), true
+		}
+		return m.GetGauge().GetValue(), true
+	}
+	return 0, false
+}
+
+func TestExporterUp(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want float64
+	}{
+		{name: "up", want: 1},
+		{name: "down", err: errors.New("connection refused"), want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			families := gather(t, &fakeSource{err: tt.err}, map[string]collector{
+				"session": NewSessionCollector(NamingLegacy),
+			})
+
+			if got, _ := metricValue(families["transmission_up"], nil); got != tt.want {
+				t.Errorf("transmission_up = %v, want %v", got, tt.want)
+			}
+			got, ok := metricValue(families["transmission_exporter_scrape_success"], map[string]string{"collector": "session"})
+			if !ok || got != tt.want {
+				t.Errorf("transmission_exporter_scrape_success = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
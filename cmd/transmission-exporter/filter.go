@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	transmission "github.com/metalmatze/transmission-exporter"
+)
+
+// torrentStatuses maps a torrent's status to its name
+var torrentStatuses = map[int]string{
+	0: "stopped",
+	1: "check_wait",
+	2: "checking",
+	3: "download_wait",
+	4: "downloading",
+	5: "seed_wait",
+	6: "seeding",
+}
+
+// TorrentFilter decides which torrents per-torrent metrics are exported for
+type TorrentFilter struct {
+	// Include only matches torrents matching all of the configured rules
+	Include FilterRules `yaml:"include" toml:"include"`
+	// Exclude drops torrents matching any of the configured rules
+	Exclude FilterRules `yaml:"exclude" toml:"exclude"`
+	// MinSize drops torrents with a total size smaller than it in bytes
+	MinSize int64 `yaml:"min_size" toml:"min_size"`
+	// ApplyToAggregates drops filtered torrents from aggregate metrics too
+	ApplyToAggregates bool `yaml:"apply_to_aggregates" toml:"apply_to_aggregates"`
+}
+
+// FilterRules match torrents by different properties,
+// every rule matches if any of its values matches
+type FilterRules struct {
+	// Names are regular expressions matching a torrent's name
+	Names []string `yaml:"names" toml:"names"`
+	// DownloadDirs are prefixes of a torrent's download directory
+	DownloadDirs []string `yaml:"download_dirs" toml:"download_dirs"`
+	// Statuses are names of a torrent's status, e.g. downloading or seeding
+	Statuses []string `yaml:"statuses" toml:"statuses"`
+	// Trackers are hosts of a torrent's trackers, subdomains match too
+	Trackers []string `yaml:"trackers" toml:"trackers"`
+	// Labels are labels a torrent has
+	Labels []string `yaml:"labels" toml:"labels"`
+
+	names    []*regexp.Regexp
+	statuses map[int]bool
+}
+
+// Compile validates the filter and compiles its rules
+func (f *TorrentFilter) Compile() error {
+	if err := f.Include.compile(); err != nil {
+		return fmt.Errorf("invalid include filter: %v", err)
+	}
+	if err := f.Exclude.compile(); err != nil {
+		return fmt.Errorf("invalid exclude filter: %v", err)
+	}
+	return nil
+}
+
+// Match returns true if per-torrent metrics should be exported for the torrent,
+// a nil filter matches all torrents
+func (f *TorrentFilter) Match(t transmission.Torrent) bool {
+	if f == nil {
+		return true
+	}
+	if f.MinSize > 0 && int64(t.TotalSize) < f.MinSize {
+		return false
+	}
+	if !f.Include.matchAll(t) {
+		return false
+	}
+	if f.Exclude.matchAny(t) {
+		return false
+	}
+	return true
+}
+
+// MatchAggregate returns true if the torrent should count towards aggregate metrics
+func (f *TorrentFilter) MatchAggregate(t transmission.Torrent) bool {
+	if f == nil || !f.ApplyToAggregates {
+		return true
+	}
+	return f.Match(t)
+}
+
+func (r *FilterRules) compile() error {
+	r.names = nil
+	for _, n := range r.Names {
+		re, err := regexp.Compile(n)
+		if err != nil {
+			return err
+		}
+		r.names = append(r.names, re)
+	}
+
+	r.statuses = make(map[int]bool)
+	for _, s := range r.Statuses {
+		found := false
+		for status, name := range torrentStatuses {
+			if strings.EqualFold(s, name) {
+				r.statuses[status] = true
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown status %q", s)
+		}
+	}
+
+	return nil
+}
+
+// matchAll returns true if all configured rules match the torrent
+func (r *FilterRules) matchAll(t transmission.Torrent) bool {
+	for _, m := range r.matchers() {
+		if m.configured && !m.match(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny returns true if any configured rule matches the torrent
+func (r *FilterRules) matchAny(t transmission.Torrent) bool {
+	for _, m := range r.matchers() {
+		if m.configured && m.match(t) {
+			return true
+		}
+	}
+	return false
+}
+
+type filterMatcher struct {
+	configured bool
+	match      func(t transmission.Torrent) bool
+}
+
+func (r *FilterRules) matchers() []filterMatcher {
+	return []filterMatcher{
+		{len(r.names) > 0, r.matchName},
+		{len(r.DownloadDirs) > 0, r.matchDownloadDir},
+		{len(r.statuses) > 0, r.matchStatus},
+		{len(r.Trackers) > 0, r.matchTracker},
+		{len(r.Labels) > 0, r.matchLabel},
+	}
+}
+
+func (r *FilterRules) matchName(t transmission.Torrent) bool {
+	for _, re := range r.names {
+		if re.MatchString(t.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *FilterRules) matchDownloadDir(t transmission.Torrent) bool {
+	for _, dir := range r.DownloadDirs {
+		if strings.HasPrefix(t.DownloadDir, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *FilterRules) matchStatus(t transmission.Torrent) bool {
+	return r.statuses[t.Status]
+}
+
+func (r *FilterRules) matchTracker(t transmission.Torrent) bool {
+	for _, tracker := range t.TrackerStats {
+		host := trackerHostname(tracker.Host)
+		for _, h := range r.Trackers {
+			h = trackerHostname(h)
+			if host == h || strings.HasSuffix(host, "."+h) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trackerHostname returns the lowercase hostname of a tracker's host,
+// which Transmission reports as scheme://host:port or host:port depending on its version
+func trackerHostname(host string) string {
+	if strings.Contains(host, "://") {
+		if u, err := url.Parse(host); err == nil {
+			return strings.ToLower(u.Hostname())
+		}
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return strings.ToLower(h)
+	}
+	return strings.ToLower(host)
+}
+
+func (r *FilterRules) matchLabel(t transmission.Torrent) bool {
+	for _, label := range t.Labels {
+		for _, l := range r.Labels {
+			if label == l {
+				return true
+			}
+		}
+	}
+	return false
+}
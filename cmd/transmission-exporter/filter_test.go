@@ -0,0 +1,230 @@
+package main
+
+import (
+	"testing"
+
+	transmission "github.com/metalmatze/transmission-exporter"
+)
+
+func TestTrackerHostname(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		// Transmission 4
+		{"https://tracker.example.org:443", "tracker.example.org"},
+		{"udp://Tracker.Example.org:6969", "tracker.example.org"},
+		{"http://[2001:db8::1]:80", "2001:db8::1"},
+		// Transmission 2 and 3
+		{"tracker.example.org:443", "tracker.example.org"},
+		{"[2001:db8::1]:6969", "2001:db8::1"},
+		// Filter values
+		{"example.org", "example.org"},
+	}
+
+	for _, tt := range tests {
+		if got := trackerHostname(tt.host); got != tt.want {
+			t.Errorf("trackerHostname(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestTorrentFilter(t *testing.T) {
+	ubuntu := transmission.Torrent{
+		Name:        "ubuntu-24.04-desktop-amd64.iso",
+		DownloadDir: "/downloads/linux",
+		Status:      6,
+		TotalSize:   6 << 30,
+		Labels:      []string{"linux", "iso"},
+		TrackerStats: []transmission.TrackerStat{
+			{Host: "https://torrent.ubuntu.com:443"},
+			{Host: "udp://tracker.opentrackr.org:1337"},
+		},
+	}
+	// Transmission before 4.0 reports the host with port only
+	debian := transmission.Torrent{
+		Name:        "debian-12.5.0-amd64-netinst.iso",
+		DownloadDir: "/downloads/linux/debian",
+		Status:      4,
+		TotalSize:   600 << 20,
+		TrackerStats: []transmission.TrackerStat{
+			{Host: "bttracker.debian.org:6969"},
+		},
+	}
+	movie := transmission.Torrent{
+		Name:        "Big Buck Bunny",
+		DownloadDir: "/downloads/movies",
+		Status:      0,
+		TotalSize:   300 << 20,
+		Labels:      []string{"movie"},
+		TrackerStats: []transmission.TrackerStat{
+			{Host: "https://tracker.example.org:443"},
+		},
+	}
+	torrents := []transmission.Torrent{ubuntu, debian, movie}
+
+	tests := []struct {
+		name   string
+		filter *TorrentFilter
+		want   []string
+	}{
+		{
+			name:   "nil",
+			filter: nil,
+			want:   []string{ubuntu.Name, debian.Name, movie.Name},
+		},
+		{
+			name:   "include name",
+			filter: &TorrentFilter{Include: FilterRules{Names: []string{`\.iso$`}}},
+			want:   []string{ubuntu.Name, debian.Name},
+		},
+		{
+			name:   "exclude name",
+			filter: &TorrentFilter{Exclude: FilterRules{Names: []string{`^ubuntu`}}},
+			want:   []string{debian.Name, movie.Name},
+		},
+		{
+			name:   "include download dir",
+			filter: &TorrentFilter{Include: FilterRules{DownloadDirs: []string{"/downloads/linux"}}},
+			want:   []string{ubuntu.Name, debian.Name},
+		},
+		{
+			name:   "exclude download dir",
+			filter: &TorrentFilter{Exclude: FilterRules{DownloadDirs: []string{"/downloads/linux/debian"}}},
+			want:   []string{ubuntu.Name, movie.Name},
+		},
+		{
+			name:   "include status",
+			filter: &TorrentFilter{Include: FilterRules{Statuses: []string{"seeding", "Downloading"}}},
+			want:   []string{ubuntu.Name, debian.Name},
+		},
+		{
+			name:   "exclude status",
+			filter: &TorrentFilter{Exclude: FilterRules{Statuses: []string{"stopped"}}},
+			want:   []string{ubuntu.Name, debian.Name},
+		},
+		{
+			name:   "include tracker with scheme and port",
+			filter: &TorrentFilter{Include: FilterRules{Trackers: []string{"torrent.ubuntu.com"}}},
+			want:   []string{ubuntu.Name},
+		},
+		{
+			name:   "include tracker with port",
+			filter: &TorrentFilter{Include: FilterRules{Trackers: []string{"bttracker.debian.org"}}},
+			want:   []string{debian.Name},
+		},
+		{
+			name:   "include tracker subdomains",
+			filter: &TorrentFilter{Include: FilterRules{Trackers: []string{"debian.org", "Example.org"}}},
+			want:   []string{debian.Name, movie.Name},
+		},
+		{
+			name:   "include tracker doesn't match partial domain",
+			filter: &TorrentFilter{Include: FilterRules{Trackers: []string{"ubuntu"}}},
+			want:   nil,
+		},
+		{
+			name:   "exclude tracker",
+			filter: &TorrentFilter{Exclude: FilterRules{Trackers: []string{"opentrackr.org", "tracker.example.org"}}},
+			want:   []string{debian.Name},
+		},
+		{
+			name:   "include label",
+			filter: &TorrentFilter{Include: FilterRules{Labels: []string{"iso"}}},
+			want:   []string{ubuntu.Name},
+		},
+		{
+			name:   "exclude label",
+			filter: &TorrentFilter{Exclude: FilterRules{Labels: []string{"movie"}}},
+			want:   []string{ubuntu.Name, debian.Name},
+		},
+		{
+			name:   "min size",
+			filter: &TorrentFilter{MinSize: 500 << 20},
+			want:   []string{ubuntu.Name, debian.Name},
+		},
+		{
+			name: "include rules must all match",
+			filter: &TorrentFilter{Include: FilterRules{
+				DownloadDirs: []string{"/downloads/linux"},
+				Statuses:     []string{"downloading"},
+			}},
+			want: []string{debian.Name},
+		},
+		{
+			name: "exclude rules match if any matches",
+			filter: &TorrentFilter{Exclude: FilterRules{
+				Names:  []string{`^debian`},
+				Labels: []string{"movie"},
+			}},
+			want: []string{ubuntu.Name},
+		},
+		{
+			name: "include, exclude and min size",
+			filter: &TorrentFilter{
+				Include: FilterRules{Trackers: []string{"ubuntu.com", "debian.org", "example.org"}},
+				Exclude: FilterRules{Statuses: []string{"stopped"}},
+				MinSize: 1 << 30,
+			},
+			want: []string{ubuntu.Name},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.filter != nil {
+				if err := tt.filter.Compile(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got []string
+			for _, torrent := range torrents {
+				if tt.filter.Match(torrent) {
+					got = append(got, torrent.Name)
+				}
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("matched %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("matched %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestTorrentFilterAggregates(t *testing.T) {
+	small := transmission.Torrent{Name: "small", TotalSize: 1 << 20}
+
+	filter := &TorrentFilter{MinSize: 1 << 30}
+	if err := filter.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	if filter.Match(small) {
+		t.Error("small torrent matches the min size")
+	}
+	if !filter.MatchAggregate(small) {
+		t.Error("filtered torrent doesn't count towards aggregates")
+	}
+
+	filter.ApplyToAggregates = true
+	if filter.MatchAggregate(small) {
+		t.Error("filtered torrent counts towards aggregates although the filter applies to them")
+	}
+}
+
+func TestTorrentFilterInvalid(t *testing.T) {
+	for _, filter := range []*TorrentFilter{
+		{Include: FilterRules{Names: []string{"("}}},
+		{Exclude: FilterRules{Statuses: []string{"paused"}}},
+	} {
+		if err := filter.Compile(); err == nil {
+			t.Errorf("expected an error compiling %+v", filter)
+		}
+	}
+}
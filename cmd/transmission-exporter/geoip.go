@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+const (
+	// geoIPUnknown is used when an address can't be resolved, e.g. private networks
+	geoIPUnknown = "unknown"
+	// geoIPOther is the bucket peers outside of the top N are summed up in
+	geoIPOther = "other"
+	// geoIPCacheSize is the number of addresses cached before the cache is reset
+	geoIPCacheSize = 65536
+)
+
+// geoLocation is the resolved location of a peer's address
+type geoLocation struct {
+	Country      string
+	ASN          string
+	Organization string
+}
+
+// GeoIP resolves peer addresses with local MaxMind databases
+// and caches the results between scrapes
+type GeoIP struct {
+	country *geoip2.Reader
+	asn     *geoip2.Reader
+
+	// TopN limits the number of countries and ASNs exported, 0 means unlimited
+	TopN int
+
+	mu    sync.Mutex
+	cache map[string]geoLocation
+}
+
+// NewGeoIP opens the GeoLite2 Country and ASN databases, either path may be empty
+func NewGeoIP(countryPath, asnPath string, topN int) (*GeoIP, error) {
+	g := &GeoIP{
+		TopN:  topN,
+		cache: make(map[string]geoLocation),
+	}
+
+	if countryPath != "" {
+		r, err := geoip2.Open(countryPath)
+		if err != nil {
+			return nil, err
+		}
+		g.country = r
+	}
+
+	if asnPath != "" {
+		r, err := geoip2.Open(asnPath)
+		if err != nil {
+			g.Close()
+			return nil, err
+		}
+		g.asn = r
+	}
+
+	return g, nil
+}
+
+// Close closes the underlying databases
+func (g *GeoIP) Close() error {
+	var err error
+	if g.country != nil {
+		err = g.country.Close()
+	}
+	if g.asn != nil {
+		if aerr := g.asn.Close(); aerr != nil {
+			err = aerr
+		}
+	}
+	return err
+}
+
+// Lookup resolves the address and caches the result
+func (g *GeoIP) Lookup(address string) geoLocation {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if loc, ok := g.cache[address]; ok {
+		return loc
+	}
+
+	loc := geoLocation{
+		Country:      geoIPUnknown,
+		ASN:          geoIPUnknown,
+		Organization: geoIPUnknown,
+	}
+
+	if ip := net.ParseIP(address); ip != nil {
+		if g.country != nil {
+			if c, err := g.country.Country(ip); err == nil && c.Country.IsoCode != "" {
+				loc.Country = c.Country.IsoCode
+			}
+		}
+		if g.asn != nil {
+			if a, err := g.asn.ASN(ip); err == nil && a.AutonomousSystemNumber != 0 {
+				loc.ASN = "AS" + strconv.FormatUint(uint64(a.AutonomousSystemNumber), 10)
+				loc.Organization = sanitizeLabel(a.AutonomousSystemOrganization, 0)
+			}
+		}
+	}
+
+	if len(g.cache) >= geoIPCacheSize {
+		g.cache = make(map[string]geoLocation)
+	}
+	g.cache[address] = loc
+
+	return loc
+}
+
+// top keeps the n groups with the highest upload rate
+// and sums up the remaining ones into the other bucket
+func (g peerGroups) top(n int) peerGroups {
+	if n <= 0 || len(g) <= n {
+		return g
+	}
+
+	values := make([]string, 0, len(g))
+	for v := range g {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		a, b := g[values[i]], g[values[j]]
+		if a.Upload != b.Upload {
+			return a.Upload > b.Upload
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return values[i] < values[j]
+	})
+
+	top := make(peerGroups, n+1)
+	other := &peerStats{}
+	for _, v := range values {
+		s := g[v]
+		if len(top) < n && v != geoIPOther {
+			top[v] = s
+			continue
+		}
+		other.Count += s.Count
+		other.Download += s.Download
+		other.Upload += s.Upload
+	}
+	top[geoIPOther] = other
+
+	return top
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+var update = flag.Bool("update", false, "update the files in testdata")
+
+const (
+	testCountryDB = "testdata/GeoLite2-Country-Test.mmdb"
+	testASNDB     = "testdata/GeoLite2-ASN-Test.mmdb"
+)
+
+// writeTestDB writes the records keyed by network into a MaxMind database
+func writeTestDB(t *testing.T, path, databaseType string, records map[string]mmdbtype.Map) {
+	t.Helper()
+
+	tree, err := mmdbwriter.New(mmdbwriter.Options{
+		DatabaseType: databaseType,
+		RecordSize:   24,
+		BuildEpoch:   1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for cidr, record := range records {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Insert(network, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := tree.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func country(isoCode string) mmdbtype.Map {
+	return mmdbtype.Map{"country": mmdbtype.Map{"iso_code": mmdbtype.String(isoCode)}}
+}
+
+func asn(number uint32, organization string) mmdbtype.Map {
+	return mmdbtype.Map{
+		"autonomous_system_number":       mmdbtype.Uint32(number),
+		"autonomous_system_organization": mmdbtype.String(organization),
+	}
+}
+
+// openTestGeoIP opens the databases in testdata, run go test -update to regenerate them
+func openTestGeoIP(t *testing.T, topN int) *GeoIP {
+	t.Helper()
+
+	if *update {
+		writeTestDB(t, testCountryDB, "GeoLite2-Country", map[string]mmdbtype.Map{
+			"81.2.69.0/24":   country("GB"),
+			"89.160.20.0/24": country("SE"),
+			"2a02:cf40::/29": country("NO"),
+		})
+		writeTestDB(t, testASNDB, "GeoLite2-ASN", map[string]mmdbtype.Map{
+			"81.2.69.0/24":   asn(20712, "Andrews & Arnold Ltd"),
+			"89.160.20.0/24": asn(29518, "Bredband2 AB"),
+		})
+	}
+
+	g, err := NewGeoIP(testCountryDB, testASNDB, topN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { g.Close() })
+	return g
+}
+
+func TestGeoIPLookup(t *testing.T) {
+	g := openTestGeoIP(t, 0)
+
+	tests := []struct {
+		address string
+		want    geoLocation
+	}{
+		{"81.2.69.142", geoLocation{Country: "GB", ASN: "AS20712", Organization: "Andrews & Arnold Ltd"}},
+		{"89.160.20.112", geoLocation{Country: "SE", ASN: "AS29518", Organization: "Bredband2 AB"}},
+		// Only in the country database
+		{"2a02:cf40::1", geoLocation{Country: "NO", ASN: geoIPUnknown, Organization: geoIPUnknown}},
+		// In neither database
+		{"192.168.1.10", geoLocation{Country: geoIPUnknown, ASN: geoIPUnknown, Organization: geoIPUnknown}},
+		{"8.8.8.8", geoLocation{Country: geoIPUnknown, ASN: geoIPUnknown, Organization: geoIPUnknown}},
+		{"not an address", geoLocation{Country: geoIPUnknown, ASN: geoIPUnknown, Organization: geoIPUnknown}},
+	}
+
+	for _, tt := range tests {
+		// Twice to look up the cached location too
+		for i := 0; i < 2; i++ {
+			if got := g.Lookup(tt.address); got != tt.want {
+				t.Errorf("Lookup(%q) = %+v, want %+v", tt.address, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestGeoIPWithoutDatabases(t *testing.T) {
+	g, err := NewGeoIP("", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	want := geoLocation{Country: geoIPUnknown, ASN: geoIPUnknown, Organization: geoIPUnknown}
+	if got := g.Lookup("81.2.69.142"); got != want {
+		t.Errorf("Lookup() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPeerGroupsTop(t *testing.T) {
+	groups := peerGroups{
+		"GB": {Count: 3, Download: 30, Upload: 300},
+		"SE": {Count: 2, Download: 20, Upload: 200},
+		"NO": {Count: 4, Download: 10, Upload: 100},
+		// Ties on upload are broken by count
+		"DE":         {Count: 5, Download: 5, Upload: 50},
+		"FR":         {Count: 1, Download: 5, Upload: 50},
+		geoIPUnknown: {Count: 1, Download: 1, Upload: 1},
+	}
+
+	tests := []struct {
+		name string
+		n    int
+		want peerGroups
+	}{
+		{
+			name: "unlimited",
+			n:    0,
+			want: groups,
+		},
+		{
+			name: "fewer groups than n",
+			n:    6,
+			want: groups,
+		},
+		{
+			name: "top 2",
+			n:    2,
+			want: peerGroups{
+				"GB":       {Count: 3, Download: 30, Upload: 300},
+				"SE":       {Count: 2, Download: 20, Upload: 200},
+				geoIPOther: {Count: 11, Download: 21, Upload: 201},
+			},
+		},
+		{
+			name: "tie broken by count",
+			n:    4,
+			want: peerGroups{
+				"GB":       {Count: 3, Download: 30, Upload: 300},
+				"SE":       {Count: 2, Download: 20, Upload: 200},
+				"NO":       {Count: 4, Download: 10, Upload: 100},
+				"DE":       {Count: 5, Download: 5, Upload: 50},
+				geoIPOther: {Count: 2, Download: 6, Upload: 51},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := groups.top(tt.n); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("top(%d) = %v, want %v", tt.n, dump(got), dump(tt.want))
+			}
+		})
+	}
+}
+
+func TestPeerGroupsTopFoldsExistingOther(t *testing.T) {
+	groups := peerGroups{
+		geoIPOther: {Count: 10, Download: 1000, Upload: 1000},
+		"GB":       {Count: 1, Download: 1, Upload: 10},
+		"SE":       {Count: 1, Download: 1, Upload: 5},
+	}
+
+	want := peerGroups{
+		"GB":       {Count: 1, Download: 1, Upload: 10},
+		"SE":       {Count: 1, Download: 1, Upload: 5},
+		geoIPOther: {Count: 10, Download: 1000, Upload: 1000},
+	}
+	if got := groups.top(2); !reflect.DeepEqual(got, want) {
+		t.Errorf("top(2) = %v, want %v", dump(got), dump(want))
+	}
+}
+
+// dump returns the groups with their values for error messages
+func dump(g peerGroups) map[string]peerStats {
+	m := make(map[string]peerStats, len(g))
+	for k, v := range g {
+		m[k] = *v
+	}
+	return m
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	transmission "github.com/metalmatze/transmission-exporter"
+)
+
+// readiness tracks if the last RPC of every instance succeeded
+type readiness struct {
+	// timeout cancels the checks, 0 disables it
+	timeout time.Duration
+
+	mu           sync.RWMutex
+	instances    map[string]*instanceReadiness
+	shuttingDown bool
+}
+
+type instanceReadiness struct {
+	observed bool
+	err      error
+	// check sends an RPC if none was observed yet, e.g. before the first scrape
+	check func(ctx context.Context) error
+}
+
+func newReadiness(timeout time.Duration) *readiness {
+	return &readiness{
+		timeout:   timeout,
+		instances: make(map[string]*instanceReadiness),
+	}
+}
+
+// add tracks the instance, check is called if no RPC was observed yet
+func (r *readiness) add(name string, check func(ctx context.Context) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.instances[name] = &instanceReadiness{check: check}
+}
+
+// observe is meant to be called with the RequestInfo of every RPC of the instance
+func (r *readiness) observe(name string, info transmission.RequestInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, ok := r.instances[name]
+	if !ok {
+		return
+	}
+	i.observed = true
+	i.err = info.Err
+}
+
+// shutdown makes the exporter unready, so no new scrapes are routed to it
+func (r *readiness) shutdown() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.shuttingDown = true
+}
+
+// unready returns the names of the instances whose last RPC failed,
+// the checks of instances without RPCs yet are canceled with ctx or the timeout
+func (r *readiness) unready(ctx context.Context) []string {
+	r.mu.RLock()
+	var unchecked []func(ctx context.Context) error
+	for _, i := range r.instances {
+		if !i.observed {
+			unchecked = append(unchecked, i.check)
+		}
+	}
+	r.mu.RUnlock()
+
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	// The checks' RPCs are observed like all others
+	var wg sync.WaitGroup
+	for _, check := range unchecked {
+		wg.Add(1)
+		go func(check func(ctx context.Context) error) {
+			defer wg.Done()
+			check(ctx)
+		}(check)
+	}
+	wg.Wait()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var names []string
+	for name, i := range r.instances {
+		if !i.observed || i.err != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// ServeHTTP responds with 200 if the last RPC of every instance succeeded,
+// 503 otherwise or once the exporter is shutting down
+func (r *readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.RLock()
+	shuttingDown := r.shuttingDown
+	r.mu.RUnlock()
+
+	if shuttingDown {
+		http.Error(w, "Shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if names := r.unready(req.Context()); len(names) > 0 {
+		http.Error(w, fmt.Sprintf("Transmission is not reachable: %s", strings.Join(names, ", ")), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("Ready\n"))
+}
+
+// healthy responds with 200 as long as the exporter is serving
+func healthy(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("Healthy\n"))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	transmission "github.com/metalmatze/transmission-exporter"
+)
+
+func TestReadiness(t *testing.T) {
+	r := newReadiness(50 * time.Millisecond)
+	r.add("up", func(ctx context.Context) error {
+		r.observe("up", transmission.RequestInfo{})
+		return nil
+	})
+	// hanging only returns once the check is canceled
+	r.add("hanging", func(ctx context.Context) error {
+		<-ctx.Done()
+		r.observe("hanging", transmission.RequestInfo{Err: ctx.Err()})
+		return ctx.Err()
+	})
+
+	done := make(chan struct{})
+	var rec *httptest.ResponseRecorder
+	go func() {
+		defer close(done)
+		rec = httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", "/-/ready", nil))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("readiness check wasn't canceled after the timeout")
+	}
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if got := r.unready(context.Background()); len(got) != 1 || got[0] != "hanging" {
+		t.Errorf("unready instances = %v, want [hanging]", got)
+	}
+}
+
+func TestReadinessShutdown(t *testing.T) {
+	r := newReadiness(0)
+	r.add("up", func(ctx context.Context) error { return nil })
+	r.observe("up", transmission.RequestInfo{})
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/-/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d before shutdown, want %d", rec.Code, http.StatusOK)
+	}
+
+	r.shutdown()
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/-/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d after shutdown, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
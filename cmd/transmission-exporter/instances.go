@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	transmission "github.com/metalmatze/transmission-exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// instanceLabel is added to every series of an instance of the config file
+const instanceLabel = "instance_name"
+
+// Instance is a Transmission instance scraped on /metrics
+type Instance struct {
+	Name     string `yaml:"name" toml:"name"`
+	Address  string `yaml:"address" toml:"address"`
+	Username string `yaml:"username" toml:"username"`
+	Password string `yaml:"password" toml:"password"`
+	// UsernameFile and PasswordFile are re-read if Transmission rejects the credentials
+	UsernameFile string `yaml:"username_file" toml:"username_file"`
+	PasswordFile string `yaml:"password_file" toml:"password_file"`
+	// Labels are added to every series of the instance
+	Labels map[string]string `yaml:"labels" toml:"labels"`
+}
+
+func (i Instance) credentials() credentials {
+	return credentials{
+		Username:     i.Username,
+		Password:     i.Password,
+		UsernameFile: i.UsernameFile,
+		PasswordFile: i.PasswordFile,
+	}
+}
+
+// validateInstances checks names are unique and all instances have the same
+// label names, as Prometheus requires consistent labels within a metric
+func validateInstances(instances []Instance) error {
+	names := make(map[string]bool)
+	var labelNames string
+
+	for n, i := range instances {
+		if i.Name == "" {
+			return fmt.Errorf("instance %d has no name", n)
+		}
+		if names[i.Name] {
+			return fmt.Errorf("instance %q is configured more than once", i.Name)
+		}
+		names[i.Name] = true
+
+		if i.Address == "" {
+			return fmt.Errorf("instance %q has no address", i.Name)
+		}
+
+		var keys []string
+		for k := range i.Labels {
+			if !model.LabelName(k).IsValidLegacy() {
+				return fmt.Errorf("instance %q has an invalid label name %q", i.Name, k)
+			}
+			if k == instanceLabel {
+				return fmt.Errorf("instance %q must not set the %s label", i.Name, instanceLabel)
+			}
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		if n == 0 {
+			labelNames = strings.Join(keys, ",")
+		} else if strings.Join(keys, ",") != labelNames {
+			return fmt.Errorf("instance %q has different label names than instance %q", i.Name, instances[0].Name)
+		}
+	}
+
+	return nil
+}
+
+// instanceOptions are shared by all instances scraped on /metrics
+type instanceOptions struct {
+	Logger        log.Logger
+	PollInterval  time.Duration
+	ScrapeTimeout time.Duration
+	Readiness     *readiness
+	// PieceMetrics requests the piece fields the piece metrics need
+	PieceMetrics bool
+	// Stop ends the background polling
+	Stop <-chan struct{}
+}
+
+// instanceExporter is the Exporter of an instance with the labels added to its series
+type instanceExporter struct {
+	Name     string
+	Address  string
+	Labels   prometheus.Labels
+	Exporter *Exporter
+}
+
+// registerInstance registers the RPC and polling metrics of the client's instance
+// and returns its Exporter, which is served by the metricsHandler
+func registerInstance(reg prometheus.Registerer, name string, client *transmission.Client, opts instanceOptions, collectors map[string]collector) *Exporter {
+	logger := log.With(opts.Logger, "instance", name)
+
+	client.PieceFields = opts.PieceMetrics
+
+	rpc := newRPCMetrics()
+	client.OnRequest = func(info transmission.RequestInfo) {
+		rpc.Observe(info)
+		opts.Readiness.observe(name, info)
+		logRequest(logger, info)
+	}
+
+	shared := newSharedSource(client, opts.ScrapeTimeout)
+	opts.Readiness.add(name, func(ctx context.Context) error {
+		_, err := shared.GetSession(ctx)
+		return err
+	})
+
+	var src source = shared
+	if opts.PollInterval > 0 {
+		poller := newPollingSource(logger, src, opts.PollInterval, opts.ScrapeTimeout)
+		go poller.Run(opts.Stop)
+		reg.MustRegister(poller)
+		src = poller
+	}
+
+	reg.MustRegister(rpc)
+	return NewExporter(logger, src, opts.ScrapeTimeout, collectors)
+}
+
+// registerInstances registers every instance with its labels
+func registerInstances(reg prometheus.Registerer, instances []Instance, opts instanceOptions, collectors func() map[string]collector) ([]instanceExporter, error) {
+	var exporters []instanceExporter
+	for _, i := range instances {
+		labels := prometheus.Labels{instanceLabel: i.Name}
+		for k, v := range i.Labels {
+			labels[k] = v
+		}
+
+		client, err := i.credentials().client(strings.TrimSuffix(i.Address, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("instance %q: %v", i.Name, err)
+		}
+		exporter := registerInstance(prometheus.WrapRegistererWith(labels, reg), i.Name, client, opts, collectors())
+		exporters = append(exporters, instanceExporter{Name: i.Name, Address: i.Address, Labels: labels, Exporter: exporter})
+	}
+
+	return exporters, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// sanitizeLabel makes s a valid UTF-8 label value without control characters,
+// collapses whitespace and truncates it to max runes if max is greater than 0
+func sanitizeLabel(s string, max int) string {
+	s = strings.ToValidUTF8(s, string(utf8.RuneError))
+	s = strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	}), " ")
+
+	if max > 0 && utf8.RuneCountInString(s) > max {
+		runes := []rune(s)
+		s = string(runes[:max]) + "…"
+	}
+
+	return s
+}
+
+// torrentLabels are the labels that can identify a torrent
+var torrentLabels = []string{"hash", "id", "name"}
+
+// parseTorrentLabels parses a comma separated list of torrent identity labels
+func parseTorrentLabels(s string) ([]string, error) {
+	var labels []string
+	seen := make(map[string]bool)
+
+	for _, l := range strings.Split(s, ",") {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+
+		valid := false
+		for _, tl := range torrentLabels {
+			if l == tl {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown torrent label %q, valid labels are %s", l, strings.Join(torrentLabels, ", "))
+		}
+		if seen[l] {
+			return nil, fmt.Errorf("duplicate torrent label %q", l)
+		}
+		seen[l] = true
+		labels = append(labels, l)
+	}
+
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("at least one torrent label of %s is required", strings.Join(torrentLabels, ", "))
+	}
+
+	return labels, nil
+}
+
+// withLabels returns a copy of labels with the extra labels appended
+func withLabels(labels []string, extra ...string) []string {
+	out := make([]string, 0, len(labels)+len(extra))
+	out = append(out, labels...)
+	return append(out, extra...)
+}
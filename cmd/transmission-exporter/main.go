@@ -1,13 +1,17 @@
 package main
 
 import (
-	"log"
+	"context"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	arg "github.com/alexflint/go-arg"
+	"github.com/go-kit/log/level"
 	"github.com/joho/godotenv"
-	transmission "github.com/metalmatze/transmission-exporter"
-	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Config gets its content from env and passes it on to different packages
@@ -17,39 +21,280 @@ type Config struct {
 	TransmissionUsername string `arg:"env:TRANSMISSION_USERNAME"`
 	WebAddr              string `arg:"env:WEB_ADDR"`
 	WebPath              string `arg:"env:WEB_PATH"`
+	GeoIPCountryDB       string `arg:"--geoip.country-db,env:GEOIP_COUNTRY_DB"`
+	GeoIPASNDB           string `arg:"--geoip.asn-db,env:GEOIP_ASN_DB"`
+	GeoIPTopN            int    `arg:"--geoip.top-n,env:GEOIP_TOP_N"`
+	TorrentFileMetrics   bool   `arg:"--torrent.file-metrics,env:TORRENT_FILE_METRICS"`
+	TorrentPieceMetrics  bool   `arg:"--torrent.piece-metrics,env:TORRENT_PIECE_METRICS"`
+	TorrentErrorLength   int    `arg:"--torrent.error-length,env:TORRENT_ERROR_LENGTH"`
+	TorrentLabels        string `arg:"--torrent.labels,env:TORRENT_LABELS"`
+	TorrentNameLength    int    `arg:"--torrent.name-length,env:TORRENT_NAME_LENGTH"`
+	TorrentMaxSeries     int    `arg:"--torrent.max-series,env:TORRENT_MAX_SERIES"`
+	TorrentTopN          int    `arg:"--torrent.top-n,env:TORRENT_TOP_N"`
+	TorrentTopBy         string `arg:"--torrent.top-by,env:TORRENT_TOP_BY"`
+	ConfigFile           string `arg:"--config.file,env:CONFIG_FILE"`
+	ConfigCheck          bool   `arg:"--config.check" help:"validate the configuration and exit"`
+	WebConfigFile        string `arg:"--web.config.file,env:WEB_CONFIG_FILE"`
+	MetricsNaming        string `arg:"--metrics.naming,env:METRICS_NAMING"`
+
+	TorrentIncludeName      string   `arg:"--torrent.include-name,env:TORRENT_INCLUDE_NAME"`
+	TorrentExcludeName      string   `arg:"--torrent.exclude-name,env:TORRENT_EXCLUDE_NAME"`
+	TorrentIncludeDir       []string `arg:"--torrent.include-dir,env:TORRENT_INCLUDE_DIR"`
+	TorrentExcludeDir       []string `arg:"--torrent.exclude-dir,env:TORRENT_EXCLUDE_DIR"`
+	TorrentIncludeStatus    []string `arg:"--torrent.include-status,env:TORRENT_INCLUDE_STATUS"`
+	TorrentExcludeStatus    []string `arg:"--torrent.exclude-status,env:TORRENT_EXCLUDE_STATUS"`
+	TorrentIncludeTracker   []string `arg:"--torrent.include-tracker,env:TORRENT_INCLUDE_TRACKER"`
+	TorrentExcludeTracker   []string `arg:"--torrent.exclude-tracker,env:TORRENT_EXCLUDE_TRACKER"`
+	TorrentIncludeLabel     []string `arg:"--torrent.include-label,env:TORRENT_INCLUDE_LABEL"`
+	TorrentExcludeLabel     []string `arg:"--torrent.exclude-label,env:TORRENT_EXCLUDE_LABEL"`
+	TorrentMinSize          int64    `arg:"--torrent.min-size,env:TORRENT_MIN_SIZE"`
+	TorrentFilterAggregates bool     `arg:"--torrent.filter-aggregates,env:TORRENT_FILTER_AGGREGATES"`
+
+	PollInterval    time.Duration `arg:"--poll.interval,env:POLL_INTERVAL"`
+	ScrapeTimeout   time.Duration `arg:"--scrape.timeout,env:SCRAPE_TIMEOUT"`
+	ShutdownTimeout time.Duration `arg:"--shutdown.timeout,env:SHUTDOWN_TIMEOUT"`
+	LogLevel        string        `arg:"--log.level,env:LOG_LEVEL" help:"debug, info, warn or error"`
+	LogFormat       string        `arg:"--log.format,env:LOG_FORMAT" help:"logfmt or json"`
+
+	TransmissionPasswordFile string `arg:"--transmission.password-file,env:TRANSMISSION_PASSWORD_FILE"`
+	TransmissionUsernameFile string `arg:"--transmission.username-file,env:TRANSMISSION_USERNAME_FILE"`
+
+	CollectorTorrent      bool `arg:"--collector.torrent,env:COLLECTOR_TORRENT" help:"disable with --collector.torrent=false"`
+	CollectorSession      bool `arg:"--collector.session,env:COLLECTOR_SESSION"`
+	CollectorSessionStats bool `arg:"--collector.session-stats,env:COLLECTOR_SESSION_STATS"`
+	CollectorPeer         bool `arg:"--collector.peer,env:COLLECTOR_PEER"`
+	CollectorAggregate    bool `arg:"--collector.aggregate,env:COLLECTOR_AGGREGATE"`
+
+	PushInterval        time.Duration `arg:"--push.interval,env:PUSH_INTERVAL"`
+	PushPushgatewayURL  string        `arg:"--push.pushgateway-url,env:PUSH_PUSHGATEWAY_URL"`
+	PushRemoteWriteURL  string        `arg:"--push.remote-write-url,env:PUSH_REMOTE_WRITE_URL"`
+	PushOTLPURL         string        `arg:"--push.otlp-url,env:PUSH_OTLP_URL"`
+	PushJob             string        `arg:"--push.job,env:PUSH_JOB"`
+	PushGrouping        []string      `arg:"--push.grouping,env:PUSH_GROUPING" help:"key=value labels of the grouping key"`
+	PushUsername        string        `arg:"--push.username,env:PUSH_USERNAME"`
+	PushPassword        string        `arg:"--push.password,env:PUSH_PASSWORD"`
+	PushBearerToken     string        `arg:"--push.bearer-token,env:PUSH_BEARER_TOKEN"`
+	PushBearerTokenFile string        `arg:"--push.bearer-token-file,env:PUSH_BEARER_TOKEN_FILE"`
 }
 
 func main() {
-	log.Println("starting transmission-exporter")
+	dotenvErr := godotenv.Load()
+
+	c := Config{
+		WebPath:            "/metrics",
+		WebAddr:            ":19091",
+		TransmissionAddr:   "http://localhost:9091",
+		GeoIPTopN:          20,
+		TorrentErrorLength: 128,
+		TorrentLabels:      "id,name",
+		TorrentTopBy:       "upload",
+		MetricsNaming:      "legacy",
+		ScrapeTimeout:      10 * time.Second,
+		ShutdownTimeout:    30 * time.Second,
+		LogLevel:           "info",
+		LogFormat:          "logfmt",
+
+		CollectorTorrent:      true,
+		CollectorSession:      true,
+		CollectorSessionStats: true,
+		CollectorPeer:         true,
+		CollectorAggregate:    true,
+
+		PushInterval: time.Minute,
+		PushJob:      "transmission",
+	}
+
+	// The config file is applied before env vars and flags, as they take precedence
+	var fileConfig *FileConfig
+	var fileConfigErr error
+	if path := configFilePath(os.Args[1:]); path != "" {
+		fileConfig, fileConfigErr = loadConfigFile(path)
+		if fileConfigErr == nil {
+			fileConfig.apply(&c)
+		}
+	}
+
+	p := arg.MustParse(&c)
 
-	err := godotenv.Load()
+	logger, err := newLogger(c.LogLevel, c.LogFormat)
 	if err != nil {
-		log.Println("no .env present")
+		p.Fail(err.Error())
+	}
+	fatal := func(msg string, err error) {
+		level.Error(logger).Log("msg", msg, "err", err)
+		os.Exit(1)
 	}
 
-	c := Config{
-		WebPath:          "/metrics",
-		WebAddr:          ":19091",
-		TransmissionAddr: "http://localhost:9091",
+	if fileConfigErr != nil {
+		fatal("failed to load config file", fileConfigErr)
+	}
+
+	level.Info(logger).Log("msg", "starting transmission-exporter", "version", version, "revision", revision)
+	if dotenvErr != nil {
+		level.Debug(logger).Log("msg", "no .env present")
+	}
+
+	torrentLabels, err := parseTorrentLabels(c.TorrentLabels)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+
+	if err := validateTopBy(c.TorrentTopBy); err != nil {
+		p.Fail(err.Error())
+	}
+
+	naming, err := parseNaming(c.MetricsNaming)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+
+	filter, err := c.torrentFilter(fileConfig)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+
+	enabled := map[string]bool{
+		"torrent":       c.CollectorTorrent,
+		"session":       c.CollectorSession,
+		"session_stats": c.CollectorSessionStats,
+		"peer":          c.CollectorPeer,
+		"aggregate":     c.CollectorAggregate,
+	}
+	anyEnabled := false
+	for _, e := range enabled {
+		anyEnabled = anyEnabled || e
+	}
+	if !anyEnabled {
+		p.Fail("all collectors are disabled")
+	}
+
+	if fileConfig != nil && len(fileConfig.Instances) > 0 {
+		if err := validateInstances(fileConfig.Instances); err != nil {
+			fatal("invalid instances in config file", err)
+		}
+	}
+	if fileConfig != nil {
+		if err := validateAuthModules(fileConfig.AuthModules); err != nil {
+			fatal("invalid auth modules in config file", err)
+		}
+	}
+
+	push, err := c.pushOptions(fileConfig)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+
+	web, err := newWebServer(logger, c.WebConfigFile)
+	if err != nil {
+		fatal("failed to load web config file", err)
+	}
+
+	var geoip *GeoIP
+	if c.GeoIPCountryDB != "" || c.GeoIPASNDB != "" {
+		geoip, err = NewGeoIP(c.GeoIPCountryDB, c.GeoIPASNDB, c.GeoIPTopN)
+		if err != nil {
+			fatal("failed to open geoip databases", err)
+		}
+		defer geoip.Close()
 	}
 
-	arg.MustParse(&c)
+	creds := credentials{
+		Username:     c.TransmissionUsername,
+		Password:     c.TransmissionPassword,
+		UsernameFile: c.TransmissionUsernameFile,
+		PasswordFile: c.TransmissionPasswordFile,
+	}
+	if err := checkCredentials(creds, fileConfig); err != nil {
+		fatal("failed to read credentials", err)
+	}
 
-	var user *transmission.User
-	if c.TransmissionUsername != "" && c.TransmissionPassword != "" {
-		user = &transmission.User{
-			Username: c.TransmissionUsername,
-			Password: c.TransmissionPassword,
+	if c.ConfigCheck {
+		level.Info(logger).Log("msg", "configuration is valid")
+		return
+	}
+
+	// collectors are created for every instance and probe, as they keep state between scrapes
+	collectors := func() map[string]collector {
+		all := map[string]collector{
+			"torrent": NewTorrentCollector(TorrentOptions{
+				FileMetrics:        c.TorrentFileMetrics,
+				PieceMetrics:       c.TorrentPieceMetrics,
+				ErrorMessageLength: c.TorrentErrorLength,
+				Labels:             torrentLabels,
+				NameMaxLength:      c.TorrentNameLength,
+				MaxSeries:          c.TorrentMaxSeries,
+				Filter:             filter,
+				TopN:               c.TorrentTopN,
+				TopBy:              c.TorrentTopBy,
+				Naming:             naming,
+				Logger:             logger,
+			}),
+			"session":       NewSessionCollector(naming),
+			"session_stats": NewSessionStatsCollector(naming),
+			"peer":          NewPeerCollector(geoip, filter, naming),
+			"aggregate":     NewAggregateCollector(filter, naming),
+		}
+		for name := range all {
+			if !enabled[name] {
+				delete(all, name)
+			}
 		}
+		return all
+	}
+
+	stop := make(chan struct{})
+	ready := newReadiness(c.ScrapeTimeout)
+	opts := instanceOptions{
+		Logger:        logger,
+		PollInterval:  c.PollInterval,
+		ScrapeTimeout: c.ScrapeTimeout,
+		Readiness:     ready,
+		PieceMetrics:  c.TorrentPieceMetrics,
+		Stop:          stop,
 	}
 
-	client := transmission.New(c.TransmissionAddr, user)
+	registry := newRegistry()
+	process := newProcessRegistry()
 
-	prometheus.MustRegister(NewTorrentCollector(client))
-	prometheus.MustRegister(NewSessionCollector(client))
-	prometheus.MustRegister(NewSessionStatsCollector(client))
+	var exporters []instanceExporter
+	if fileConfig != nil && len(fileConfig.Instances) > 0 {
+		exporters, err = registerInstances(registry, fileConfig.Instances, opts, collectors)
+		if err != nil {
+			fatal("failed to create instances", err)
+		}
+	} else {
+		client, err := creds.client(c.TransmissionAddr)
+		if err != nil {
+			fatal("failed to create client", err)
+		}
+		exporter := registerInstance(registry, "transmission", client, opts, collectors())
+		exporters = []instanceExporter{{Name: "transmission", Address: c.TransmissionAddr, Exporter: exporter}}
+	}
 
-	http.Handle(c.WebPath, prometheus.Handler())
+	metrics := &metricsHandler{
+		logger:    logger,
+		registry:  registry,
+		process:   process,
+		exporters: exporters,
+		naming:    naming,
+	}
+	http.Handle(c.WebPath, promhttp.InstrumentMetricHandler(process, metrics))
+	http.HandleFunc("/-/healthy", healthy)
+	http.Handle("/-/ready", ready)
+
+	var modules map[string]AuthModule
+	if fileConfig != nil {
+		modules = fileConfig.AuthModules
+	}
+	http.Handle("/probe", &probeHandler{
+		logger:       logger,
+		modules:      modules,
+		timeout:      c.ScrapeTimeout,
+		collectors:   collectors,
+		pieceMetrics: c.TorrentPieceMetrics,
+		registry:     process,
+		naming:       naming,
+	})
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
@@ -61,7 +306,33 @@ func main() {
 			</html>`))
 	})
 
-	log.Fatal(http.ListenAndServe(c.WebAddr, nil))
+	go web.watch(stop)
+
+	if push.enabled() {
+		go newPusher(logger, push, registry, exporters).Run(stop)
+	}
+
+	server := web.Server(c.WebAddr, http.DefaultServeMux)
+	go func() {
+		if err := web.ListenAndServe(server); err != http.ErrServerClosed {
+			fatal("failed to serve", err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	level.Info(logger).Log("msg", "shutting down, draining in-flight scrapes")
+	// Unready first, so load balancers stop sending scrapes while draining
+	ready.shutdown()
+	close(stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		level.Error(logger).Log("msg", "failed to drain in-flight scrapes", "err", err)
+	}
 }
 
 func boolToString(true bool) string {
@@ -70,3 +341,10 @@ func boolToString(true bool) string {
 	}
 	return "0"
 }
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// newRegistry creates the registry of the exporter's own metrics,
+// which is served on /metrics and pushed next to the metrics of the instances
+func newRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newBuildInfo())
+	return registry
+}
+
+// newProcessRegistry creates the registry of the Go runtime, process and handler metrics,
+// which describe the exporter's process and are only served on /metrics, not pushed
+func newProcessRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return registry
+}
+
+// handlerOpts negotiates OpenMetrics including the _created samples of counters.
+// With NamingBoth only the text format is served, as OpenMetrics strips the _total
+// of counters and the v2 counters would clash with the legacy gauges of the same name,
+// e.g. transmission_session_stats_downloaded_bytes.
+// Errors are logged and counted in promhttp_metric_handler_errors_total of reg,
+// the scrape continues with the metrics that could be gathered.
+func handlerOpts(logger log.Logger, reg prometheus.Registerer, naming Naming) promhttp.HandlerOpts {
+	return promhttp.HandlerOpts{
+		ErrorLog:                            promhttpLogger{logger},
+		ErrorHandling:                       promhttp.ContinueOnError,
+		Registry:                            reg,
+		EnableOpenMetrics:                   naming != NamingBoth,
+		EnableOpenMetricsTextCreatedSamples: true,
+	}
+}
+
+// promhttpLogger logs the errors of promhttp handlers
+type promhttpLogger struct {
+	logger log.Logger
+}
+
+// Println implements the promhttp.Logger interface
+func (l promhttpLogger) Println(v ...interface{}) {
+	level.Error(l.logger).Log("msg", "failed to serve metrics", "err", redact(strings.TrimSpace(fmt.Sprintln(v...))))
+}
+
+// metricsHandler serves the metrics of the registries and the instances' Exporters.
+// Like node_exporter, collect[] URL parameters select the collectors to run,
+// so expensive collectors can be scraped less often than cheap ones.
+type metricsHandler struct {
+	logger    log.Logger
+	registry  *prometheus.Registry
+	process   *prometheus.Registry
+	exporters []instanceExporter
+	naming    Naming
+}
+
+func (h *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	gatherer, err := h.gatherer(r.URL.Query()["collect[]"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	promhttp.HandlerFor(gatherer, handlerOpts(h.logger, h.process, h.naming)).ServeHTTP(w, r)
+}
+
+// gatherer returns the registries and the Exporters only running the named collectors,
+// all collectors if names is empty
+func (h *metricsHandler) gatherer(names []string) (prometheus.Gatherer, error) {
+	// Every scrape gets its own registry, as the collectors differ between scrapes
+	registry := prometheus.NewRegistry()
+	for _, ie := range h.exporters {
+		exporter, err := ie.Exporter.view(names)
+		if err != nil {
+			return nil, err
+		}
+		prometheus.WrapRegistererWith(ie.Labels, registry).MustRegister(exporter)
+	}
+
+	return prometheus.Gatherers{h.process, h.registry, registry}, nil
+}
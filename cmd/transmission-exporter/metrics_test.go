@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestMetricsHandlerOpenMetrics(t *testing.T) {
+	tests := []struct {
+		naming Naming
+		want   string
+	}{
+		{naming: NamingLegacy, want: "application/openmetrics-text"},
+		{naming: NamingV2, want: "application/openmetrics-text"},
+		// The legacy gauges and v2 counters clash in OpenMetrics
+		{naming: NamingBoth, want: "text/plain"},
+	}
+
+	for _, tt := range tests {
+		exporter := NewExporter(log.NewNopLogger(), &fakeSource{}, 0, map[string]collector{
+			"session_stats": NewSessionStatsCollector(tt.naming),
+		})
+		h := &metricsHandler{
+			logger:    log.NewNopLogger(),
+			registry:  newRegistry(),
+			process:   newProcessRegistry(),
+			exporters: []instanceExporter{{Name: "transmission", Exporter: exporter}},
+			naming:    tt.naming,
+		}
+
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		r.Header.Set("Accept", "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.want) {
+			t.Errorf("naming %d: got content type %q, want %s", tt.naming, ct, tt.want)
+		}
+		if tt.naming != NamingBoth {
+			continue
+		}
+
+		var parser expfmt.TextParser
+		families, err := parser.TextToMetricFamilies(rec.Body)
+		if err != nil {
+			t.Fatalf("naming both isn't parseable: %v", err)
+		}
+		for _, name := range []string{"transmission_session_stats_downloaded_bytes", "transmission_session_stats_downloaded_bytes_total"} {
+			if _, ok := families[name]; !ok {
+				t.Errorf("naming both doesn't serve %s", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Naming selects the metric names exported for metrics that got renamed
+type Naming int
+
+const (
+	// NamingLegacy only exports the original metric names as gauges
+	NamingLegacy Naming = iota
+	// NamingV2 only exports names following the Prometheus naming conventions
+	NamingV2
+	// NamingBoth exports legacy and v2 names to migrate dashboards gradually
+	NamingBoth
+)
+
+// parseNaming parses legacy, v2 or both
+func parseNaming(s string) (Naming, error) {
+	switch s {
+	case "legacy":
+		return NamingLegacy, nil
+	case "v2":
+		return NamingV2, nil
+	case "both":
+		return NamingBoth, nil
+	default:
+		return NamingLegacy, fmt.Errorf("unknown metrics naming %q, valid namings are legacy, v2 and both", s)
+	}
+}
+
+// renamedMetric is a metric exported under its legacy name as gauge,
+// under its v2 name with the correct type, or under both
+type renamedMetric struct {
+	legacy     *prometheus.Desc
+	v2         *prometheus.Desc
+	v2Type     prometheus.ValueType
+	legacyOnly bool
+	v2Only     bool
+}
+
+func newRenamedMetric(naming Naming, legacy, v2 string, v2Type prometheus.ValueType, help string, labels []string) *renamedMetric {
+	return &renamedMetric{
+		legacy:     prometheus.NewDesc(legacy, help, labels, nil),
+		v2:         prometheus.NewDesc(v2, help, labels, nil),
+		v2Type:     v2Type,
+		legacyOnly: naming == NamingLegacy,
+		v2Only:     naming == NamingV2,
+	}
+}
+
+func (m *renamedMetric) describe(ch chan<- *prometheus.Desc) {
+	if !m.v2Only {
+		ch <- m.legacy
+	}
+	if !m.legacyOnly {
+		ch <- m.v2
+	}
+}
+
+// collect sends the value under the enabled names
+func (m *renamedMetric) collect(ch chan<- prometheus.Metric, value float64, labels ...string) {
+	m.collectValues(ch, value, value, labels...)
+}
+
+// collectCreated sends the value under the enabled names, a v2 counter
+// with the time it started counting, exposed as _created sample by OpenMetrics
+func (m *renamedMetric) collectCreated(ch chan<- prometheus.Metric, value float64, created time.Time, labels ...string) {
+	m.collectValuesCreated(ch, value, value, created, labels...)
+}
+
+// collectValues sends different values under the legacy and v2 name,
+// e.g. if the legacy metric used the wrong unit
+func (m *renamedMetric) collectValues(ch chan<- prometheus.Metric, legacyValue, v2Value float64, labels ...string) {
+	m.collectValuesCreated(ch, legacyValue, v2Value, time.Time{}, labels...)
+}
+
+// collectValuesCreated sends different values like collectValues
+// and the created time of a v2 counter, which is ignored if zero
+func (m *renamedMetric) collectValuesCreated(ch chan<- prometheus.Metric, legacyValue, v2Value float64, created time.Time, labels ...string) {
+	for _, metric := range m.metrics(legacyValue, v2Value, created, labels...) {
+		ch <- metric
+	}
+}
+
+// metrics returns the metrics collectValuesCreated sends
+func (m *renamedMetric) metrics(legacyValue, v2Value float64, created time.Time, labels ...string) []prometheus.Metric {
+	var metrics []prometheus.Metric
+	if !m.v2Only {
+		metrics = append(metrics, prometheus.MustNewConstMetric(m.legacy, prometheus.GaugeValue, legacyValue, labels...))
+	}
+	if !m.legacyOnly {
+		if m.v2Type == prometheus.CounterValue && !created.IsZero() {
+			metrics = append(metrics, prometheus.MustNewConstMetricWithCreatedTimestamp(m.v2, m.v2Type, v2Value, created, labels...))
+		} else {
+			metrics = append(metrics, prometheus.MustNewConstMetric(m.v2, m.v2Type, v2Value, labels...))
+		}
+	}
+	return metrics
+}
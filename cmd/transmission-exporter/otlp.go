@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// The OTLP/HTTP JSON encoding of opentelemetry.proto.collector.metrics.v1.ExportMetricsServiceRequest,
+// only containing what's needed for gauges and cumulative sums
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpMetric struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Gauge       *otlpGauge `json:"gauge,omitempty"`
+	Sum         *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+// otlpAggregationTemporalityCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE
+const otlpAggregationTemporalityCumulative = 2
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64          `json:"startTimeUnixNano,string,omitempty"`
+	TimeUnixNano      uint64          `json:"timeUnixNano,string"`
+	AsDouble          float64         `json:"asDouble"`
+}
+
+type otlpAttribute struct {
+	Key   string             `json:"key"`
+	Value otlpAttributeValue `json:"value"`
+}
+
+type otlpAttributeValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// otlpResourceMetricsOf translates the instance's metrics into OTLP gauges and cumulative sums.
+// The resource identifies the instance by its name, address and labels,
+// which are dropped from the data points. Counters without created timestamp start at start.
+func otlpResourceMetricsOf(ie instanceExporter, families []*dto.MetricFamily, start time.Time) otlpResourceMetrics {
+	resource := map[string]string{
+		"service.name":               "transmission",
+		"service.instance.id":        ie.Name,
+		"transmission.instance.name": ie.Name,
+		"transmission.address":       redact(ie.Address),
+	}
+	for k, v := range ie.Labels {
+		if k != instanceLabel {
+			resource[k] = v
+		}
+	}
+
+	now := uint64(time.Now().UnixNano())
+
+	var metrics []otlpMetric
+	for _, mf := range families {
+		var points []otlpDataPoint
+		for _, m := range mf.GetMetric() {
+			point := otlpDataPoint{TimeUnixNano: now}
+			for _, l := range m.GetLabel() {
+				if _, ok := ie.Labels[l.GetName()]; ok {
+					continue
+				}
+				point.Attributes = append(point.Attributes, otlpAttribute{
+					Key:   l.GetName(),
+					Value: otlpAttributeValue{StringValue: l.GetValue()},
+				})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				point.AsDouble = m.GetCounter().GetValue()
+				point.StartTimeUnixNano = uint64(start.UnixNano())
+				if ct := m.GetCounter().GetCreatedTimestamp(); ct != nil {
+					point.StartTimeUnixNano = uint64(ct.AsTime().UnixNano())
+				}
+			case dto.MetricType_GAUGE:
+				point.AsDouble = m.GetGauge().GetValue()
+			case dto.MetricType_UNTYPED:
+				point.AsDouble = m.GetUntyped().GetValue()
+			default:
+				// The collectors only export gauges and counters
+				continue
+			}
+			// JSON can't encode them as numbers
+			if math.IsNaN(point.AsDouble) || math.IsInf(point.AsDouble, 0) {
+				continue
+			}
+			points = append(points, point)
+		}
+		if len(points) == 0 {
+			continue
+		}
+
+		metric := otlpMetric{Name: mf.GetName(), Description: mf.GetHelp()}
+		if mf.GetType() == dto.MetricType_COUNTER {
+			metric.Sum = &otlpSum{
+				DataPoints:             points,
+				AggregationTemporality: otlpAggregationTemporalityCumulative,
+				IsMonotonic:            true,
+			}
+		} else {
+			metric.Gauge = &otlpGauge{DataPoints: points}
+		}
+		metrics = append(metrics, metric)
+	}
+
+	return otlpResourceMetrics{
+		Resource: otlpResource{Attributes: otlpAttributes(resource)},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "github.com/metalmatze/transmission-exporter", Version: version},
+			Metrics: metrics,
+		}},
+	}
+}
+
+// otlpAttributes returns the attributes sorted by key
+func otlpAttributes(m map[string]string) []otlpAttribute {
+	attributes := make([]otlpAttribute, 0, len(m))
+	for k, v := range m {
+		attributes = append(attributes, otlpAttribute{Key: k, Value: otlpAttributeValue{StringValue: v}})
+	}
+	sort.Slice(attributes, func(i, j int) bool { return attributes[i].Key < attributes[j].Key })
+	return attributes
+}
+
+// otlpExport sends the metrics to an OTLP/HTTP endpoint using the JSON encoding
+func otlpExport(ctx context.Context, client httpDoer, url string, resources []otlpResourceMetrics) error {
+	body, err := json.Marshal(otlpRequest{ResourceMetrics: resources})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "transmission-exporter/"+version)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("otlp export returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+
+	return nil
+}
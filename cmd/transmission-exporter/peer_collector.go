@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	transmission "github.com/metalmatze/transmission-exporter"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// clientFamilies maps lowercase ClientName prefixes to a bounded set of
+// client family label values. More specific prefixes have to come first.
+var clientFamilies = []struct {
+	prefix string
+	family string
+}{
+	{"transmission", "transmission"},
+	{"qbittorrent", "qbittorrent"},
+	{"µtorrent", "utorrent"},
+	{"utorrent", "utorrent"},
+	{"bittorrent", "bittorrent"},
+	{"libtorrent (rakshasa)", "rtorrent"},
+	{"rtorrent", "rtorrent"},
+	{"libtorrent", "libtorrent"},
+	{"deluge", "deluge"},
+	{"vuze", "vuze"},
+	{"azureus", "vuze"},
+	{"biglybt", "biglybt"},
+	{"bitcomet", "bitcomet"},
+	{"xunlei", "xunlei"},
+	{"aria2", "aria2"},
+	{"tixati", "tixati"},
+	{"ktorrent", "ktorrent"},
+	{"picotorrent", "picotorrent"},
+	{"webtorrent", "webtorrent"},
+	{"free download manager", "fdm"},
+	{"unknown", "unknown"},
+}
+
+// peerClientFamily parses the client family out of a peer's ClientName,
+// e.g. "qBittorrent 4.3.1" becomes "qbittorrent"
+func peerClientFamily(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return "unknown"
+	}
+	for _, f := range clientFamilies {
+		if strings.HasPrefix(name, f.prefix) {
+			return f.family
+		}
+	}
+	return "other"
+}
+
+// peerAddressFamily returns ipv4 or ipv6 depending on the peer's address
+func peerAddressFamily(address string) string {
+	ip := net.ParseIP(address)
+	if ip != nil && ip.To4() == nil {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// peerDescs are the metrics exported for every value of a peer dimension
+type peerDescs struct {
+	Peers    *prometheus.Desc
+	Download *renamedMetric
+	Upload   *renamedMetric
+}
+
+func newPeerDescs(naming Naming, dimension, help, label string) peerDescs {
+	const collectorNamespace = "peer_"
+
+	return peerDescs{
+		Peers: prometheus.NewDesc(
+			namespace+collectorNamespace+dimension+"_peers",
+			"The number of connected peers by "+help,
+			[]string{label},
+			nil,
+		),
+		Download: newRenamedMetric(
+			naming,
+			namespace+collectorNamespace+dimension+"_download_bytes",
+			namespace+collectorNamespace+dimension+"_download_bytes_per_second",
+			prometheus.GaugeValue,
+			"The current download rate from peers by "+help+" in bytes",
+			[]string{label},
+		),
+		Upload: newRenamedMetric(
+			naming,
+			namespace+collectorNamespace+dimension+"_upload_bytes",
+			namespace+collectorNamespace+dimension+"_upload_bytes_per_second",
+			prometheus.GaugeValue,
+			"The current upload rate to peers by "+help+" in bytes",
+			[]string{label},
+		),
+	}
+}
+
+func (d peerDescs) describe(ch chan<- *prometheus.Desc) {
+	ch <- d.Peers
+	d.Download.describe(ch)
+	d.Upload.describe(ch)
+}
+
+// peerStats sums up the peers sharing the same label value
+type peerStats struct {
+	Count    int
+	Download int
+	Upload   int
+}
+
+// peerGroups maps label values to the summed up peerStats
+type peerGroups map[string]*peerStats
+
+func (g peerGroups) add(value string, p transmission.Peer) {
+	s, ok := g[value]
+	if !ok {
+		s = &peerStats{}
+		g[value] = s
+	}
+	s.Count++
+	s.Download += p.RateToClient
+	s.Upload += p.RateToPeer
+}
+
+func (g peerGroups) collect(ch chan<- prometheus.Metric, d peerDescs) {
+	for value, s := range g {
+		ch <- prometheus.MustNewConstMetric(
+			d.Peers,
+			prometheus.GaugeValue,
+			float64(s.Count),
+			value,
+		)
+		d.Download.collect(ch, float64(s.Download), value)
+		d.Upload.collect(ch, float64(s.Upload), value)
+	}
+}
+
+// PeerCollector aggregates the peers of all torrents into metrics
+// without any per-peer or per-torrent labels to keep cardinality bounded
+type PeerCollector struct {
+	geoip  *GeoIP
+	filter *TorrentFilter
+
+	Client        peerDescs
+	Encryption    peerDescs
+	Transport     peerDescs
+	Direction     peerDescs
+	AddressFamily peerDescs
+	State         peerDescs
+
+	// GeoIP
+	Country peerDescs
+	ASN     peerDescs
+	ASNInfo *prometheus.Desc
+}
+
+// NewPeerCollector creates a new peer collector,
+// geoip is optional and enables the country and ASN metrics
+func NewPeerCollector(geoip *GeoIP, filter *TorrentFilter, naming Naming) *PeerCollector {
+	return &PeerCollector{
+		geoip:  geoip,
+		filter: filter,
+
+		Client:        newPeerDescs(naming, "client", "BitTorrent client family", "client"),
+		Encryption:    newPeerDescs(naming, "encryption", "encryption", "encrypted"),
+		Transport:     newPeerDescs(naming, "transport", "transport protocol", "transport"),
+		Direction:     newPeerDescs(naming, "direction", "connection direction", "direction"),
+		AddressFamily: newPeerDescs(naming, "address_family", "IP address family", "family"),
+		State:         newPeerDescs(naming, "state", "choke and interest state", "state"),
+
+		// GeoIP
+		Country: newPeerDescs(naming, "country", "country", "country"),
+		ASN:     newPeerDescs(naming, "asn", "autonomous system", "asn"),
+		ASNInfo: prometheus.NewDesc(
+			namespace+"peer_asn_info",
+			"The organization of an autonomous system peers are connected from",
+			[]string{"asn", "organization"},
+			nil,
+		),
+	}
+}
+
+// Describe implements the prometheus.Collector interface
+func (pc *PeerCollector) Describe(ch chan<- *prometheus.Desc) {
+	pc.Client.describe(ch)
+	pc.Encryption.describe(ch)
+	pc.Transport.describe(ch)
+	pc.Direction.describe(ch)
+	pc.AddressFamily.describe(ch)
+	pc.State.describe(ch)
+
+	if pc.geoip != nil {
+		pc.Country.describe(ch)
+		pc.ASN.describe(ch)
+		ch <- pc.ASNInfo
+	}
+}
+
+// RPCs implements the collector interface
+func (pc *PeerCollector) RPCs() []string {
+	return []string{torrentGet}
+}
+
+// Update implements the collector interface
+func (pc *PeerCollector) Update(ch chan<- prometheus.Metric, s *snapshot) error {
+	if err := s.err(torrentGet); err != nil {
+		return fmt.Errorf("failed to get torrents: %v", err)
+	}
+	torrents := s.Torrents
+
+	clients := peerGroups{}
+	encryption := peerGroups{"0": {}, "1": {}}
+	transport := peerGroups{"tcp": {}, "utp": {}}
+	direction := peerGroups{"incoming": {}, "outgoing": {}}
+	families := peerGroups{"ipv4": {}, "ipv6": {}}
+	states := peerGroups{
+		"client_choked":     {},
+		"client_interested": {},
+		"peer_choked":       {},
+		"peer_interested":   {},
+		"downloading_from":  {},
+		"uploading_to":      {},
+	}
+	countries := peerGroups{}
+	asns := peerGroups{}
+	organizations := map[string]string{}
+
+	for _, t := range torrents {
+		if !pc.filter.MatchAggregate(t) {
+			continue
+		}
+
+		for _, p := range t.Peers {
+			clients.add(peerClientFamily(p.ClientName), p)
+			encryption.add(boolToString(p.IsEncrypted), p)
+			families.add(peerAddressFamily(p.Address), p)
+
+			if p.IsUTP {
+				transport.add("utp", p)
+			} else {
+				transport.add("tcp", p)
+			}
+
+			if p.IsIncoming {
+				direction.add("incoming", p)
+			} else {
+				direction.add("outgoing", p)
+			}
+
+			if p.ClientIsChoked {
+				states.add("client_choked", p)
+			}
+			if p.ClientIsInterested {
+				states.add("client_interested", p)
+			}
+			if p.PeerIsChoked {
+				states.add("peer_choked", p)
+			}
+			if p.PeerIsInterested {
+				states.add("peer_interested", p)
+			}
+			if p.IsDownloadingFrom {
+				states.add("downloading_from", p)
+			}
+			if p.IsUploadingTo {
+				states.add("uploading_to", p)
+			}
+
+			if pc.geoip != nil {
+				loc := pc.geoip.Lookup(p.Address)
+				countries.add(loc.Country, p)
+				asns.add(loc.ASN, p)
+				organizations[loc.ASN] = loc.Organization
+			}
+		}
+	}
+
+	clients.collect(ch, pc.Client)
+	encryption.collect(ch, pc.Encryption)
+	transport.collect(ch, pc.Transport)
+	direction.collect(ch, pc.Direction)
+	families.collect(ch, pc.AddressFamily)
+	states.collect(ch, pc.State)
+
+	if pc.geoip == nil {
+		return nil
+	}
+
+	if pc.geoip.country != nil {
+		countries.top(pc.geoip.TopN).collect(ch, pc.Country)
+	}
+	if pc.geoip.asn != nil {
+		asns = asns.top(pc.geoip.TopN)
+		asns.collect(ch, pc.ASN)
+
+		for asn := range asns {
+			organization, ok := organizations[asn]
+			if !ok {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(
+				pc.ASNInfo,
+				prometheus.GaugeValue,
+				1,
+				asn, organization,
+			)
+		}
+	}
+
+	return nil
+}
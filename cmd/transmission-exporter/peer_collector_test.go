@@ -0,0 +1,193 @@
+package main
+
+import (
+	"testing"
+
+	transmission "github.com/metalmatze/transmission-exporter"
+)
+
+func TestPeerClientFamily(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Transmission 4.0.5", "transmission"},
+		{"qBittorrent 4.3.1", "qbittorrent"},
+		{"µTorrent 3.5.5", "utorrent"},
+		{"uTorrent Mac 1.8.7", "utorrent"},
+		{"BitTorrent 7.10.5", "bittorrent"},
+		{"libTorrent (Rakshasa) 0.13.8", "rtorrent"},
+		{"rTorrent 0.9.8", "rtorrent"},
+		{"libtorrent (Rasterbar) 2.0.9", "libtorrent"},
+		{"Deluge 2.1.1", "deluge"},
+		{"Azureus 5.7.6.0", "vuze"},
+		{"Vuze 5.7.7.0", "vuze"},
+		{"BiglyBT 3.5.0.0", "biglybt"},
+		{"BitComet 1.98", "bitcomet"},
+		{"Xunlei 0.0.1.2", "xunlei"},
+		{"aria2/1.36.0", "aria2"},
+		{"Tixati 3.19", "tixati"},
+		{"KTorrent 5.2.0", "ktorrent"},
+		{"PicoTorrent 3.3.0", "picotorrent"},
+		{"WebTorrent 1.9.7", "webtorrent"},
+		{"Free Download Manager 6", "fdm"},
+		{"Unknown Client", "unknown"},
+		{"  Transmission 3.00  ", "transmission"},
+		{"", "unknown"},
+		{"  ", "unknown"},
+		{"Some New Client 1.0", "other"},
+	}
+
+	for _, tt := range tests {
+		if got := peerClientFamily(tt.name); got != tt.want {
+			t.Errorf("peerClientFamily(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPeerAddressFamily(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"81.2.69.142", "ipv4"},
+		{"2a02:cf40::1", "ipv6"},
+		{"::1", "ipv6"},
+		// IPv4-mapped IPv6 addresses are IPv4 peers
+		{"::ffff:81.2.69.142", "ipv4"},
+		// Unparseable addresses are counted as IPv4
+		{"", "ipv4"},
+		{"not an address", "ipv4"},
+	}
+
+	for _, tt := range tests {
+		if got := peerAddressFamily(tt.address); got != tt.want {
+			t.Errorf("peerAddressFamily(%q) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestPeerCollector(t *testing.T) {
+	torrents := []transmission.Torrent{
+		{
+			ID:        1,
+			TotalSize: 1 << 30,
+			Peers: []transmission.Peer{
+				{
+					Address: "81.2.69.142", ClientName: "qBittorrent 4.3.1",
+					IsEncrypted: true, IsUTP: true, IsIncoming: true,
+					ClientIsInterested: true, IsDownloadingFrom: true,
+					RateToClient: 100, RateToPeer: 10,
+				},
+				{
+					Address: "89.160.20.112", ClientName: "Transmission 4.0.5",
+					ClientIsChoked: true, PeerIsInterested: true, IsUploadingTo: true,
+					RateToClient: 50, RateToPeer: 300,
+				},
+				{
+					Address: "2a02:cf40::1", ClientName: "µTorrent 3.5.5",
+					IsEncrypted: true, IsIncoming: true, PeerIsChoked: true,
+					RateToPeer: 5,
+				},
+			},
+		},
+		{
+			ID:        2,
+			TotalSize: 1 << 30,
+			Peers: []transmission.Peer{
+				{Address: "81.2.69.143", RateToPeer: 20},
+			},
+		},
+		// Filtered from the aggregates
+		{
+			ID:        3,
+			TotalSize: 1 << 10,
+			Peers: []transmission.Peer{
+				{Address: "89.160.20.113", ClientName: "Deluge 2.1.1", RateToPeer: 1000},
+			},
+		},
+	}
+
+	filter := &TorrentFilter{MinSize: 1 << 20, ApplyToAggregates: true}
+	if err := filter.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	families := gather(t, &fakeSource{torrents: torrents}, map[string]collector{
+		"peer": NewPeerCollector(openTestGeoIP(t, 1), filter, NamingLegacy),
+	})
+
+	tests := []struct {
+		metric string
+		labels map[string]string
+		want   float64
+	}{
+		{"transmission_peer_client_peers", map[string]string{"client": "qbittorrent"}, 1},
+		{"transmission_peer_client_peers", map[string]string{"client": "transmission"}, 1},
+		{"transmission_peer_client_peers", map[string]string{"client": "utorrent"}, 1},
+		{"transmission_peer_client_peers", map[string]string{"client": "unknown"}, 1},
+		{"transmission_peer_client_download_bytes", map[string]string{"client": "qbittorrent"}, 100},
+		{"transmission_peer_client_upload_bytes", map[string]string{"client": "transmission"}, 300},
+
+		{"transmission_peer_encryption_peers", map[string]string{"encrypted": "1"}, 2},
+		{"transmission_peer_encryption_peers", map[string]string{"encrypted": "0"}, 2},
+		{"transmission_peer_transport_peers", map[string]string{"transport": "utp"}, 1},
+		{"transmission_peer_transport_peers", map[string]string{"transport": "tcp"}, 3},
+		{"transmission_peer_transport_download_bytes", map[string]string{"transport": "utp"}, 100},
+		{"transmission_peer_direction_peers", map[string]string{"direction": "incoming"}, 2},
+		{"transmission_peer_direction_peers", map[string]string{"direction": "outgoing"}, 2},
+		{"transmission_peer_address_family_peers", map[string]string{"family": "ipv4"}, 3},
+		{"transmission_peer_address_family_peers", map[string]string{"family": "ipv6"}, 1},
+		{"transmission_peer_address_family_upload_bytes", map[string]string{"family": "ipv6"}, 5},
+
+		{"transmission_peer_state_peers", map[string]string{"state": "client_choked"}, 1},
+		{"transmission_peer_state_peers", map[string]string{"state": "client_interested"}, 1},
+		{"transmission_peer_state_peers", map[string]string{"state": "peer_choked"}, 1},
+		{"transmission_peer_state_peers", map[string]string{"state": "peer_interested"}, 1},
+		{"transmission_peer_state_peers", map[string]string{"state": "downloading_from"}, 1},
+		{"transmission_peer_state_peers", map[string]string{"state": "uploading_to"}, 1},
+
+		// Only the top country and ASN by upload, the rest is summed up as other
+		{"transmission_peer_country_peers", map[string]string{"country": "SE"}, 1},
+		{"transmission_peer_country_peers", map[string]string{"country": geoIPOther}, 3},
+		{"transmission_peer_country_upload_bytes", map[string]string{"country": geoIPOther}, 35},
+		{"transmission_peer_asn_peers", map[string]string{"asn": "AS29518"}, 1},
+		{"transmission_peer_asn_peers", map[string]string{"asn": geoIPOther}, 3},
+		{"transmission_peer_asn_info", map[string]string{"asn": "AS29518", "organization": "Bredband2 AB"}, 1},
+	}
+	for _, tt := range tests {
+		if got, ok := metricValue(families[tt.metric], tt.labels); !ok || got != tt.want {
+			t.Errorf("%s%v = %v, want %v", tt.metric, tt.labels, got, tt.want)
+		}
+	}
+
+	series := map[string]int{
+		"transmission_peer_client_peers":  4,
+		"transmission_peer_country_peers": 2,
+		"transmission_peer_asn_peers":     2,
+		"transmission_peer_asn_info":      1,
+	}
+	for metric, want := range series {
+		if got := len(families[metric].GetMetric()); got != want {
+			t.Errorf("%s has %d series, want %d", metric, got, want)
+		}
+	}
+}
+
+func TestPeerCollectorWithoutGeoIP(t *testing.T) {
+	torrents := []transmission.Torrent{{ID: 1, Peers: []transmission.Peer{{Address: "81.2.69.142"}}}}
+
+	families := gather(t, &fakeSource{torrents: torrents}, map[string]collector{
+		"peer": NewPeerCollector(nil, nil, NamingLegacy),
+	})
+
+	for _, name := range []string{"transmission_peer_country_peers", "transmission_peer_asn_peers", "transmission_peer_asn_info"} {
+		if _, ok := families[name]; ok {
+			t.Errorf("%s is exported without GeoIP databases", name)
+		}
+	}
+	// Buckets without peers are exported as 0
+	if got, ok := metricValue(families["transmission_peer_transport_peers"], map[string]string{"transport": "utp"}); !ok || got != 0 {
+		t.Errorf("transmission_peer_transport_peers{transport=\"utp\"} = %v, want 0", got)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	transmission "github.com/metalmatze/transmission-exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// defaultAuthModule is used by probes not passing a module
+const defaultAuthModule = "default"
+
+// probeTimeoutOffset is subtracted from the scrape timeout of Prometheus,
+// so the metrics are served before Prometheus gives up on the probe
+const probeTimeoutOffset = 500 * time.Millisecond
+
+// AuthModule holds the credentials probes use for a Transmission instance
+type AuthModule struct {
+	Username string `yaml:"username" toml:"username"`
+	Password string `yaml:"password" toml:"password"`
+	// UsernameFile and PasswordFile are read for every probe
+	UsernameFile string `yaml:"username_file" toml:"username_file"`
+	PasswordFile string `yaml:"password_file" toml:"password_file"`
+	// Targets are the only targets probed with the module,
+	// so its credentials aren't sent to arbitrary hosts
+	Targets []string `yaml:"targets" toml:"targets"`
+}
+
+// validateAuthModules returns an error if a module has no or invalid targets
+func validateAuthModules(modules map[string]AuthModule) error {
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		m := modules[name]
+		if len(m.Targets) == 0 {
+			return fmt.Errorf("auth module %q has no targets", name)
+		}
+		for _, target := range m.Targets {
+			if _, err := probeTarget(target); err != nil {
+				return fmt.Errorf("auth module %q: %v", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// allows returns true if target is one of the module's targets
+func (m AuthModule) allows(target string) bool {
+	for _, t := range m.Targets {
+		if t, err := probeTarget(t); err == nil && t == target {
+			return true
+		}
+	}
+	return false
+}
+
+func (m AuthModule) credentials() credentials {
+	return credentials{
+		Username:     m.Username,
+		Password:     m.Password,
+		UsernameFile: m.UsernameFile,
+		PasswordFile: m.PasswordFile,
+	}
+}
+
+// probeHandler scrapes the Transmission instance passed as target,
+// every request gets its own client and registry
+type probeHandler struct {
+	logger     log.Logger
+	modules    map[string]AuthModule
+	timeout    time.Duration
+	collectors func() map[string]collector
+	// pieceMetrics requests the piece fields the piece metrics need
+	pieceMetrics bool
+	// registry counts the errors of the probes' handlers
+	registry prometheus.Registerer
+	naming   Naming
+}
+
+func (h *probeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	target, err := probeTarget(query.Get("target"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	moduleName := query.Get("module")
+	if moduleName == "" {
+		moduleName = defaultAuthModule
+	}
+	// Without auth_modules in a config file no target may be probed
+	if len(h.modules) == 0 {
+		http.Error(w, "no auth_modules configured, probing requires a config file listing the allowed targets", http.StatusBadRequest)
+		return
+	}
+	module, ok := h.modules[moduleName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown module %q", moduleName), http.StatusBadRequest)
+		return
+	}
+	if !module.allows(target) {
+		http.Error(w, fmt.Sprintf("target %q is not allowed by module %q", target, moduleName), http.StatusForbidden)
+		return
+	}
+
+	timeout, err := h.probeTimeout(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, err := module.credentials().client(target)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("module %q: %v", moduleName, err), http.StatusInternalServerError)
+		return
+	}
+
+	client.PieceFields = h.pieceMetrics
+
+	logger := log.With(h.logger, "target", redact(target))
+
+	rpc := newRPCMetrics()
+	client.OnRequest = func(info transmission.RequestInfo) {
+		rpc.Observe(info)
+		logRequest(logger, info)
+	}
+
+	exporter, err := NewExporter(logger, newSharedSource(client, timeout), timeout, h.collectors()).view(query["collect[]"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+
+	// Gathered after the exporter, so the RPCs of this probe are counted
+	rpcRegistry := prometheus.NewRegistry()
+	rpcRegistry.MustRegister(rpc)
+
+	gatherers := prometheus.Gatherers{registry, rpcRegistry}
+	promhttp.HandlerFor(gatherers, handlerOpts(logger, h.registry, h.naming)).ServeHTTP(w, r)
+}
+
+// probeTimeout returns the timeout of the probe's RPCs, the scrape timeout
+// of Prometheus minus probeTimeoutOffset, at most the configured timeout
+func (h *probeHandler) probeTimeout(r *http.Request) (time.Duration, error) {
+	v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if v == "" {
+		return h.timeout, nil
+	}
+
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid X-Prometheus-Scrape-Timeout-Seconds %q", v)
+	}
+
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > probeTimeoutOffset {
+		timeout -= probeTimeoutOffset
+	}
+	if h.timeout > 0 && h.timeout < timeout {
+		timeout = h.timeout
+	}
+
+	return timeout, nil
+}
+
+// probeTarget validates the target and defaults to http if it has no scheme
+func probeTarget(target string) (string, error) {
+	if target == "" {
+		return "", fmt.Errorf("target parameter is missing")
+	}
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid target %q: %v", target, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid target %q: scheme must be http or https", target)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid target %q: host is missing", target)
+	}
+
+	return strings.TrimSuffix(target, "/"), nil
+}
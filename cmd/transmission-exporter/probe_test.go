@@ -0,0 +1,181 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// transmissionServer answers every RPC with an empty session
+// and counts the requests with the expected basic auth
+type transmissionServer struct {
+	*httptest.Server
+	requests atomic.Int32
+	authed   atomic.Int32
+}
+
+func newTransmissionServer(t *testing.T, username, password string) *transmissionServer {
+	t.Helper()
+
+	s := &transmissionServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		if u, p, ok := r.BasicAuth(); ok && u == username && p == password {
+			s.authed.Add(1)
+		}
+		w.Write([]byte(`{"arguments":{},"result":"success"}`))
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func newTestProbeHandler(modules map[string]AuthModule) *probeHandler {
+	return &probeHandler{
+		logger:  log.NewNopLogger(),
+		modules: modules,
+		timeout: 10 * time.Second,
+		collectors: func() map[string]collector {
+			return map[string]collector{"session": NewSessionCollector(NamingLegacy)}
+		},
+		registry: prometheus.NewRegistry(),
+	}
+}
+
+func TestProbeHandlerTargets(t *testing.T) {
+	allowed := newTransmissionServer(t, "bob", "secret")
+	other := newTransmissionServer(t, "bob", "secret")
+
+	h := newTestProbeHandler(map[string]AuthModule{
+		"default": {Username: "bob", Password: "secret", Targets: []string{allowed.URL + "/"}},
+		"nas":     {Username: "alice", Password: "secret", Targets: []string{strings.TrimPrefix(other.URL, "http://")}},
+	})
+
+	tests := []struct {
+		name   string
+		target string
+		module string
+		want   int
+	}{
+		{name: "default module", target: allowed.URL, want: http.StatusOK},
+		{name: "named module", target: other.URL, module: "nas", want: http.StatusOK},
+		{name: "target of another module", target: other.URL, want: http.StatusForbidden},
+		{name: "unlisted target", target: "http://attacker.example.org:9091", want: http.StatusForbidden},
+		{name: "unknown module", target: allowed.URL, module: "seedbox", want: http.StatusBadRequest},
+		{name: "missing target", want: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{"target": {tt.target}}
+			if tt.module != "" {
+				query.Set("module", tt.module)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/probe?"+query.Encode(), nil))
+
+			if rec.Code != tt.want {
+				body, _ := io.ReadAll(rec.Body)
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.want, body)
+			}
+		})
+	}
+
+	// The credentials of a module are only sent to its own targets
+	if n := allowed.authed.Load(); n == 0 {
+		t.Error("the allowed target wasn't probed with the module's credentials")
+	}
+	if n := other.authed.Load(); n != 0 {
+		t.Errorf("sent the default module's credentials to another module's target %d times", n)
+	}
+}
+
+func TestProbeHandlerWithoutModules(t *testing.T) {
+	srv := newTransmissionServer(t, "", "")
+	h := newTestProbeHandler(nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/probe?target="+url.QueryEscape(srv.URL), nil))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "auth_modules") {
+		t.Errorf("got body %q, want it to point to the auth_modules of the config file", body)
+	}
+	if n := srv.requests.Load(); n != 0 {
+		t.Errorf("probed a target without modules %d times", n)
+	}
+}
+
+func TestValidateAuthModules(t *testing.T) {
+	tests := []struct {
+		name    string
+		modules map[string]AuthModule
+		valid   bool
+	}{
+		{name: "none", valid: true},
+		{name: "targets", modules: map[string]AuthModule{"default": {Targets: []string{"nas:9091", "https://seedbox"}}}, valid: true},
+		{name: "no targets", modules: map[string]AuthModule{"default": {Username: "bob"}}},
+		{name: "invalid target", modules: map[string]AuthModule{"default": {Targets: []string{"ftp://nas"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateAuthModules(tt.modules); (err == nil) != tt.valid {
+				t.Errorf("validateAuthModules() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestProbeTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		header  string
+		want    time.Duration
+		invalid bool
+	}{
+		{name: "no header", timeout: 10 * time.Second, want: 10 * time.Second},
+		{name: "shorter scrape timeout", timeout: 10 * time.Second, header: "5", want: 4500 * time.Millisecond},
+		{name: "longer scrape timeout", timeout: 10 * time.Second, header: "30", want: 10 * time.Second},
+		{name: "without configured timeout", header: "2.5", want: 2 * time.Second},
+		{name: "scrape timeout below offset", timeout: 10 * time.Second, header: "0.2", want: 200 * time.Millisecond},
+		{name: "invalid", timeout: 10 * time.Second, header: "soon", invalid: true},
+		{name: "negative", timeout: 10 * time.Second, header: "-1", invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &probeHandler{timeout: tt.timeout}
+			r := httptest.NewRequest("GET", "/probe", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tt.header)
+			}
+
+			got, err := h.probeTimeout(r)
+			if tt.invalid {
+				if err == nil {
+					t.Errorf("expected an error, got timeout %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("probeTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// pushOptions configure pushing the metrics to receivers not scraping the exporter,
+// e.g. because it runs behind NAT or metrics are collected by an OpenTelemetry Collector
+type pushOptions struct {
+	Interval time.Duration
+
+	PushgatewayURL string
+	Job            string
+	// Grouping are the labels of the Pushgateway's grouping key besides the job,
+	// remote-written series get the job and grouping labels as external labels
+	Grouping map[string]string
+
+	RemoteWriteURL string
+
+	// OTLPURL is the OTLP/HTTP metrics endpoint, e.g. http://collector:4318/v1/metrics
+	OTLPURL string
+
+	Username        string
+	Password        string
+	BearerToken     string
+	BearerTokenFile string
+}
+
+// enabled returns true if the metrics are pushed anywhere
+func (o pushOptions) enabled() bool {
+	return o.PushgatewayURL != "" || o.RemoteWriteURL != "" || o.OTLPURL != ""
+}
+
+// parseGrouping parses the key=value pairs of a Pushgateway grouping key
+func parseGrouping(pairs []string) (map[string]string, error) {
+	grouping := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid grouping %q, must be key=value", pair)
+		}
+		if !model.LabelName(kv[0]).IsValidLegacy() {
+			return nil, fmt.Errorf("invalid grouping label name %q", kv[0])
+		}
+		grouping[kv[0]] = kv[1]
+	}
+	return grouping, nil
+}
+
+// httpDoer is implemented by http.Client
+type httpDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// authDoer adds basic auth or a bearer token to every request,
+// the token file is read for every request to pick up rotated tokens
+type authDoer struct {
+	client httpDoer
+	opts   pushOptions
+}
+
+func (d authDoer) Do(req *http.Request) (*http.Response, error) {
+	token := d.opts.BearerToken
+	if d.opts.BearerTokenFile != "" {
+		content, err := ioutil.ReadFile(d.opts.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bearer token file: %v", err)
+		}
+		token = strings.TrimSpace(string(content))
+	}
+
+	switch {
+	case token != "":
+		req.Header.Set("Authorization", "Bearer "+token)
+	case d.opts.Username != "" || d.opts.Password != "":
+		req.SetBasicAuth(d.opts.Username, d.opts.Password)
+	}
+
+	return d.client.Do(req)
+}
+
+// pusher periodically gathers the metrics and pushes them
+// to a Pushgateway, a remote-write and/or an OTLP endpoint
+type pusher struct {
+	logger    log.Logger
+	opts      pushOptions
+	registry  prometheus.Gatherer
+	exporters []instanceExporter
+	client    httpDoer
+	// start is the start time of OTLP sums without created timestamp
+	start time.Time
+}
+
+func newPusher(logger log.Logger, opts pushOptions, registry prometheus.Gatherer, exporters []instanceExporter) *pusher {
+	return &pusher{
+		logger:    logger,
+		opts:      opts,
+		registry:  registry,
+		exporters: exporters,
+		client:    authDoer{client: &http.Client{Timeout: opts.Interval}, opts: opts},
+		start:     time.Now(),
+	}
+}
+
+// Run pushes on every interval until stop is closed
+func (p *pusher) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.push()
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// push gathers the metrics once and pushes them to all configured endpoints.
+// Like the handlers it continues on errors with all metrics that could be gathered.
+func (p *pusher) push() {
+	ctx, cancel := context.WithTimeout(context.Background(), p.opts.Interval)
+	defer cancel()
+
+	// Every instance is gathered on its own, so OTLP gets its metrics per resource
+	instances := make([][]*dto.MetricFamily, len(p.exporters))
+	for n, ie := range p.exporters {
+		registry := prometheus.NewRegistry()
+		prometheus.WrapRegistererWith(ie.Labels, registry).MustRegister(ie.Exporter)
+
+		families, err := registry.Gather()
+		if err != nil {
+			level.Error(p.logger).Log("msg", "failed to gather metrics to push", "instance", ie.Name, "err", redact(err.Error()))
+		}
+		instances[n] = families
+	}
+
+	if p.opts.PushgatewayURL != "" || p.opts.RemoteWriteURL != "" {
+		// Gathered after the instances, so the RPCs of this push are counted
+		gatherers := prometheus.Gatherers{p.registry}
+		for _, families := range instances {
+			gatherers = append(gatherers, gathered(families))
+		}
+
+		families, err := gatherers.Gather()
+		if err != nil {
+			level.Error(p.logger).Log("msg", "failed to gather metrics to push", "err", redact(err.Error()))
+		}
+
+		if p.opts.PushgatewayURL != "" {
+			p.pushgateway(ctx, families)
+		}
+		if p.opts.RemoteWriteURL != "" {
+			p.remoteWrite(ctx, families)
+		}
+	}
+
+	if p.opts.OTLPURL != "" {
+		p.otlp(ctx, instances)
+	}
+}
+
+// gathered returns a Gatherer of already gathered metric families
+func gathered(families []*dto.MetricFamily) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return families, nil
+	})
+}
+
+func (p *pusher) pushgateway(ctx context.Context, families []*dto.MetricFamily) {
+	pg := push.New(p.opts.PushgatewayURL, p.opts.Job).Gatherer(gathered(families)).Client(p.client)
+	for k, v := range p.opts.Grouping {
+		pg = pg.Grouping(k, v)
+	}
+
+	start := time.Now()
+	if err := pg.PushContext(ctx); err != nil {
+		level.Error(p.logger).Log("msg", "failed to push to pushgateway", "err", redact(err.Error()))
+		return
+	}
+	level.Debug(p.logger).Log("msg", "pushed to pushgateway", "duration", time.Since(start))
+}
+
+func (p *pusher) remoteWrite(ctx context.Context, families []*dto.MetricFamily) {
+	external := map[string]string{"job": p.opts.Job}
+	for k, v := range p.opts.Grouping {
+		external[k] = v
+	}
+
+	start := time.Now()
+	if err := remoteWrite(ctx, p.client, p.opts.RemoteWriteURL, families, external); err != nil {
+		level.Error(p.logger).Log("msg", "failed to remote write", "err", redact(err.Error()))
+		return
+	}
+	level.Debug(p.logger).Log("msg", "remote wrote metrics", "families", len(families), "duration", time.Since(start))
+}
+
+func (p *pusher) otlp(ctx context.Context, instances [][]*dto.MetricFamily) {
+	resources := make([]otlpResourceMetrics, 0, len(instances))
+	for n, families := range instances {
+		resources = append(resources, otlpResourceMetricsOf(p.exporters[n], families, p.start))
+	}
+
+	start := time.Now()
+	if err := otlpExport(ctx, p.client, p.opts.OTLPURL, resources); err != nil {
+		level.Error(p.logger).Log("msg", "failed to export to otlp", "err", redact(err.Error()))
+		return
+	}
+	level.Debug(p.logger).Log("msg", "exported to otlp", "resources", len(resources), "duration", time.Since(start))
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/klauspost/compress/snappy"
+	transmission "github.com/metalmatze/transmission-exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// receiver records the requests of a push receiver
+type receiver struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T) (*receiver, *httptest.Server) {
+	t.Helper()
+
+	r := &receiver{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		r.mu.Lock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		r.mu.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+
+	return r, srv
+}
+
+// last returns the last request and its body, failing if there was none
+func (r *receiver) last(t *testing.T) (*http.Request, []byte) {
+	t.Helper()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.requests) == 0 {
+		t.Fatal("no request received")
+	}
+	return r.requests[len(r.requests)-1], r.bodies[len(r.bodies)-1]
+}
+
+// testPusher pushes the exporter's own registry, as main does, and a single
+// instance of the source with labels like an instance of the config file
+func testPusher(opts pushOptions, src source, collectors map[string]collector) *pusher {
+	exporter := NewExporter(log.NewNopLogger(), src, 0, collectors)
+	ie := instanceExporter{
+		Name:     "nas",
+		Address:  "http://nas:9091",
+		Labels:   prometheus.Labels{instanceLabel: "nas", "site": "home"},
+		Exporter: exporter,
+	}
+	return newPusher(log.NewNopLogger(), opts, newRegistry(), []instanceExporter{ie})
+}
+
+func TestPushgateway(t *testing.T) {
+	rec, srv := newReceiver(t)
+
+	testPusher(pushOptions{
+		Interval:       time.Minute,
+		PushgatewayURL: srv.URL,
+		Job:            "transmission",
+		Grouping:       map[string]string{"cluster": "home"},
+		Username:       "bob",
+		Password:       "secret",
+	}, &fakeSource{}, map[string]collector{"session": NewSessionCollector(NamingLegacy)}).push()
+
+	req, body := rec.last(t)
+	if req.Method != http.MethodPut {
+		t.Errorf("got method %s, want PUT", req.Method)
+	}
+	if want := "/metrics/job/transmission/cluster/home"; req.URL.Path != want {
+		t.Errorf("got path %s, want %s", req.URL.Path, want)
+	}
+	if u, p, ok := req.BasicAuth(); !ok || u != "bob" || p != "secret" {
+		t.Errorf("got basic auth %q, %q, want bob, secret", u, p)
+	}
+
+	families := make(map[string]*dto.MetricFamily)
+	dec := expfmt.NewDecoder(strings.NewReader(string(body)), expfmt.ResponseFormat(req.Header))
+	for {
+		var mf dto.MetricFamily
+		if err := dec.Decode(&mf); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		families[mf.GetName()] = &mf
+	}
+
+	up, ok := metricValue(families["transmission_up"], map[string]string{instanceLabel: "nas", "site": "home"})
+	if !ok || up != 1 {
+		t.Errorf("transmission_up{instance_name=\"nas\",site=\"home\"} = %v, want 1", up)
+	}
+	if _, ok := families["transmission_exporter_build_info"]; !ok {
+		t.Error("the exporter's own metrics aren't pushed")
+	}
+	for name := range families {
+		if strings.HasPrefix(name, "go_") || strings.HasPrefix(name, "process_") {
+			t.Errorf("pushed %s of the exporter's process", name)
+		}
+	}
+}
+
+// protoField is a field of a protobuf message
+type protoField struct {
+	num   protowire.Number
+	typ   protowire.Type
+	value uint64
+	bytes []byte
+}
+
+// protoFields splits a protobuf message into its fields
+func protoFields(t *testing.T, b []byte) []protoField {
+	t.Helper()
+
+	var fields []protoField
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		f := protoField{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.value, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.value, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			t.Fatalf("field %d has unexpected wire type %d", num, typ)
+		}
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// decodeWriteRequest decodes a prometheus.WriteRequest following prompb/remote.proto and prompb/types.proto,
+// failing on fields with other numbers or wire types
+func decodeWriteRequest(t *testing.T, b []byte) []remoteWriteSeries {
+	t.Helper()
+
+	var series []remoteWriteSeries
+	for _, f := range protoFields(t, b) {
+		if f.num != 1 || f.typ != protowire.BytesType {
+			t.Fatalf("unexpected field %d of WriteRequest", f.num)
+		}
+
+		var s remoteWriteSeries
+		for _, f := range protoFields(t, f.bytes) {
+			switch {
+			case f.num == 1 && f.typ == protowire.BytesType:
+				var l remoteWriteLabel
+				for _, f := range protoFields(t, f.bytes) {
+					switch {
+					case f.num == 1 && f.typ == protowire.BytesType:
+						l.Name = string(f.bytes)
+					case f.num == 2 && f.typ == protowire.BytesType:
+						l.Value = string(f.bytes)
+					default:
+						t.Fatalf("unexpected field %d of Label", f.num)
+					}
+				}
+				s.Labels = append(s.Labels, l)
+			case f.num == 2 && f.typ == protowire.BytesType:
+				var smpl remoteWriteSample
+				for _, f := range protoFields(t, f.bytes) {
+					switch {
+					case f.num == 1 && f.typ == protowire.Fixed64Type:
+						smpl.Value = math.Float64frombits(f.value)
+					case f.num == 2 && f.typ == protowire.VarintType:
+						smpl.Timestamp = int64(f.value)
+					default:
+						t.Fatalf("unexpected field %d of Sample", f.num)
+					}
+				}
+				s.Samples = append(s.Samples, smpl)
+			default:
+				t.Fatalf("unexpected field %d of TimeSeries", f.num)
+			}
+		}
+		series = append(series, s)
+	}
+	return series
+}
+
+func TestMarshalWriteRequest(t *testing.T) {
+	got := marshalWriteRequest([]remoteWriteSeries{{
+		Labels:  []remoteWriteLabel{{Name: "__name__", Value: "up"}},
+		Samples: []remoteWriteSample{{Value: 1, Timestamp: 1000}},
+	}})
+
+	// Encoded by hand following prompb/types.proto
+	want := []byte{
+		0x0a, 0x1e, // WriteRequest.timeseries, 30 bytes
+		0x0a, 0x0e, // TimeSeries.labels, 14 bytes
+		0x0a, 0x08, '_', '_', 'n', 'a', 'm', 'e', '_', '_', // Label.name
+		0x12, 0x02, 'u', 'p', // Label.value
+		0x12, 0x0c, // TimeSeries.samples, 12 bytes
+		0x09, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f, // Sample.value 1.0
+		0x10, 0xe8, 0x07, // Sample.timestamp 1000
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got\n% x\nwant\n% x", got, want)
+	}
+}
+
+func TestRemoteWrite(t *testing.T) {
+	rec, srv := newReceiver(t)
+
+	before := time.Now()
+	testPusher(pushOptions{
+		Interval:       time.Minute,
+		RemoteWriteURL: srv.URL + "/api/v1/write",
+		Job:            "transmission",
+		Grouping:       map[string]string{"cluster": "home"},
+		BearerToken:    "token",
+	}, &fakeSource{}, map[string]collector{"session": NewSessionCollector(NamingLegacy)}).push()
+	after := time.Now()
+
+	req, body := rec.last(t)
+	headers := map[string]string{
+		"Authorization":                     "Bearer token",
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	}
+	for k, want := range headers {
+		if got := req.Header.Get(k); got != want {
+			t.Errorf("header %s = %q, want %q", k, got, want)
+		}
+	}
+
+	raw, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := decodeWriteRequest(t, raw)
+
+	var up *remoteWriteSeries
+	for i, ts := range series {
+		labels := make(map[string]string, len(ts.Labels))
+		for n, l := range ts.Labels {
+			if n > 0 && ts.Labels[n-1].Name >= l.Name {
+				t.Errorf("labels of %v aren't sorted", ts.Labels)
+			}
+			labels[l.Name] = l.Value
+		}
+
+		name := labels["__name__"]
+		if strings.HasPrefix(name, "go_") || strings.HasPrefix(name, "process_") {
+			t.Errorf("remote wrote %s of the exporter's process", name)
+		}
+		if labels["job"] != "transmission" || labels["cluster"] != "home" {
+			t.Errorf("series %v doesn't have the job and grouping labels", labels)
+		}
+		if name == "transmission_up" {
+			if labels[instanceLabel] != "nas" || labels["site"] != "home" {
+				t.Errorf("transmission_up has labels %v, want the instance labels", labels)
+			}
+			up = &series[i]
+		}
+	}
+
+	if up == nil {
+		t.Fatal("transmission_up wasn't remote written")
+	}
+	if len(up.Samples) != 1 {
+		t.Fatalf("got %d samples of transmission_up, want 1", len(up.Samples))
+	}
+	s := up.Samples[0]
+	if s.Value != 1 {
+		t.Errorf("transmission_up = %v, want 1", s.Value)
+	}
+	if s.Timestamp < before.UnixMilli() || s.Timestamp > after.UnixMilli() {
+		t.Errorf("sample timestamp %d isn't the time of the push", s.Timestamp)
+	}
+}
+
+// jsonPath returns the value at the path of object keys and array indexes in decoded JSON
+func jsonPath(t *testing.T, v interface{}, path ...interface{}) interface{} {
+	t.Helper()
+
+	for _, p := range path {
+		switch p := p.(type) {
+		case string:
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				t.Fatalf("%v isn't an object with %s", v, p)
+			}
+			if v, ok = obj[p]; !ok {
+				t.Fatalf("%v has no %s", obj, p)
+			}
+		case int:
+			arr, ok := v.([]interface{})
+			if !ok || p >= len(arr) {
+				t.Fatalf("%v isn't an array with index %d", v, p)
+			}
+			v = arr[p]
+		}
+	}
+	return v
+}
+
+// otlpAttributeMap returns the string values of the OTLP JSON KeyValue list by key
+func otlpAttributeMap(t *testing.T, attributes interface{}) map[string]string {
+	t.Helper()
+
+	m := make(map[string]string)
+	if attributes == nil {
+		return m
+	}
+	list, ok := attributes.([]interface{})
+	if !ok {
+		t.Fatalf("attributes %v aren't an array", attributes)
+	}
+	for i := range list {
+		key, _ := jsonPath(t, list, i, "key").(string)
+		m[key], _ = jsonPath(t, list, i, "value", "stringValue").(string)
+	}
+	return m
+}
+
+// otlpNanos returns a fixed64 timestamp, which the OTLP JSON encoding has as string
+func otlpNanos(t *testing.T, v interface{}) uint64 {
+	t.Helper()
+
+	s, ok := v.(string)
+	if !ok {
+		t.Fatalf("timestamp %v isn't a string", v)
+	}
+	nanos, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nanos
+}
+
+func TestOTLP(t *testing.T) {
+	rec, srv := newReceiver(t)
+
+	src := &fakeSource{torrents: []transmission.Torrent{{ID: 1, Name: "ubuntu", Added: 1600000000, CorruptEver: 4096}}}
+	p := testPusher(pushOptions{
+		Interval: time.Minute,
+		OTLPURL:  srv.URL + "/v1/metrics",
+		Username: "bob",
+		Password: "secret",
+	}, src, map[string]collector{
+		"torrent": NewTorrentCollector(TorrentOptions{Naming: NamingV2, Logger: log.NewNopLogger()}),
+	})
+
+	before := uint64(time.Now().UnixNano())
+	p.push()
+	after := uint64(time.Now().UnixNano())
+
+	req, body := rec.last(t)
+	if ct := req.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got content type %q, want application/json", ct)
+	}
+	if u, p, ok := req.BasicAuth(); !ok || u != "bob" || p != "secret" {
+		t.Errorf("got basic auth %q, %q, want bob, secret", u, p)
+	}
+
+	// Decoded without the exporter's types, following the OTLP/HTTP JSON encoding of
+	// opentelemetry/proto/collector/metrics/v1/metrics_service.proto
+	var export map[string]interface{}
+	if err := json.Unmarshal(body, &export); err != nil {
+		t.Fatal(err)
+	}
+	resources, _ := jsonPath(t, export, "resourceMetrics").([]interface{})
+	if n := len(resources); n != 1 {
+		t.Fatalf("got %d resources, want 1 for the instance", n)
+	}
+
+	resource := otlpAttributeMap(t, jsonPath(t, resources, 0, "resource", "attributes"))
+	wantResource := map[string]string{
+		"service.name":               "transmission",
+		"service.instance.id":        "nas",
+		"transmission.instance.name": "nas",
+		"transmission.address":       "http://nas:9091",
+		"site":                       "home",
+	}
+	if len(resource) != len(wantResource) {
+		t.Errorf("got resource attributes %v, want %v", resource, wantResource)
+	}
+	for k, want := range wantResource {
+		if resource[k] != want {
+			t.Errorf("resource attribute %s = %q, want %q", k, resource[k], want)
+		}
+	}
+
+	metrics := make(map[string]map[string]interface{})
+	scopes, _ := jsonPath(t, resources, 0, "scopeMetrics").([]interface{})
+	for i := range scopes {
+		list, _ := jsonPath(t, scopes, i, "metrics").([]interface{})
+		for _, m := range list {
+			m := m.(map[string]interface{})
+			metrics[m["name"].(string)] = m
+		}
+	}
+
+	up, ok := metrics["transmission_up"]["gauge"]
+	if !ok {
+		t.Fatalf("transmission_up is %v, want a gauge", metrics["transmission_up"])
+	}
+	point := jsonPath(t, up, "dataPoints", 0)
+	if v := jsonPath(t, point, "asDouble"); v != 1.0 {
+		t.Errorf("transmission_up = %v, want 1", v)
+	}
+	if ts := otlpNanos(t, jsonPath(t, point, "timeUnixNano")); ts < before || ts > after {
+		t.Errorf("transmission_up has time %d, want the time of the push", ts)
+	}
+	if attrs := otlpAttributeMap(t, point.(map[string]interface{})["attributes"]); len(attrs) != 0 {
+		t.Errorf("transmission_up has attributes %v, the instance labels belong to the resource", attrs)
+	}
+
+	sum, ok := metrics["transmission_torrent_corrupt_bytes_total"]["sum"]
+	if !ok {
+		t.Fatalf("transmission_torrent_corrupt_bytes_total is %v, want a sum", metrics["transmission_torrent_corrupt_bytes_total"])
+	}
+	if v := jsonPath(t, sum, "isMonotonic"); v != true {
+		t.Error("transmission_torrent_corrupt_bytes_total isn't monotonic")
+	}
+	// AGGREGATION_TEMPORALITY_CUMULATIVE
+	if v := jsonPath(t, sum, "aggregationTemporality"); v != 2.0 {
+		t.Errorf("transmission_torrent_corrupt_bytes_total has temporality %v, want cumulative", v)
+	}
+	point = jsonPath(t, sum, "dataPoints", 0)
+	if v := jsonPath(t, point, "asDouble"); v != 4096.0 {
+		t.Errorf("transmission_torrent_corrupt_bytes_total = %v, want 4096", v)
+	}
+	if start := otlpNanos(t, jsonPath(t, point, "startTimeUnixNano")); start != uint64(time.Unix(1600000000, 0).UnixNano()) {
+		t.Errorf("transmission_torrent_corrupt_bytes_total starts at %d, want the time the torrent was added", start)
+	}
+	if ts := otlpNanos(t, jsonPath(t, point, "timeUnixNano")); ts < before || ts > after {
+		t.Errorf("transmission_torrent_corrupt_bytes_total has time %d, want the time of the push", ts)
+	}
+	if attrs := otlpAttributeMap(t, jsonPath(t, point, "attributes")); attrs["id"] != "1" || attrs["name"] != "ubuntu" {
+		t.Errorf("transmission_torrent_corrupt_bytes_total has attributes %v, want the torrent's labels", attrs)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteWriteSeries is a series of a Prometheus remote-write 1.0 request
+type remoteWriteSeries struct {
+	Labels  []remoteWriteLabel
+	Samples []remoteWriteSample
+}
+
+type remoteWriteLabel struct {
+	Name  string
+	Value string
+}
+
+type remoteWriteSample struct {
+	Value float64
+	// Timestamp in milliseconds
+	Timestamp int64
+}
+
+// remoteWriteSeriesOf flattens the metric families into series like Prometheus stores them,
+// histograms and summaries become their _bucket, _sum, _count and quantile series.
+// The external labels are added to series not having them already, like Prometheus does.
+func remoteWriteSeriesOf(families []*dto.MetricFamily, external map[string]string, now time.Time) []remoteWriteSeries {
+	var series []remoteWriteSeries
+	for _, mf := range families {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			ts := now.UnixNano() / int64(time.Millisecond)
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+
+			add := func(name string, value float64, extra ...string) {
+				series = append(series, remoteWriteSeries{
+					Labels:  remoteWriteLabels(name, m.GetLabel(), external, extra...),
+					Samples: []remoteWriteSample{{Value: value, Timestamp: ts}},
+				})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add(name, q.GetValue(), model.QuantileLabel, formatFloat(q.GetQuantile()))
+				}
+				add(name+"_sum", s.GetSampleSum())
+				add(name+"_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				infSeen := false
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						infSeen = true
+					}
+					add(name+"_bucket", float64(b.GetCumulativeCount()), model.BucketLabel, formatFloat(b.GetUpperBound()))
+				}
+				if !infSeen {
+					add(name+"_bucket", float64(h.GetSampleCount()), model.BucketLabel, "+Inf")
+				}
+				add(name+"_sum", h.GetSampleSum())
+				add(name+"_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return series
+}
+
+// remoteWriteLabels returns the sorted labels of a series including its name
+func remoteWriteLabels(name string, pairs []*dto.LabelPair, external map[string]string, extra ...string) []remoteWriteLabel {
+	labels := make([]remoteWriteLabel, 0, len(pairs)+len(external)+1+len(extra)/2)
+	labels = append(labels, remoteWriteLabel{Name: model.MetricNameLabel, Value: name})
+
+	present := make(map[string]bool, len(pairs))
+	for _, p := range pairs {
+		labels = append(labels, remoteWriteLabel{Name: p.GetName(), Value: p.GetValue()})
+		present[p.GetName()] = true
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		labels = append(labels, remoteWriteLabel{Name: extra[i], Value: extra[i+1]})
+	}
+	for k, v := range external {
+		if !present[k] {
+			labels = append(labels, remoteWriteLabel{Name: k, Value: v})
+		}
+	}
+
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return labels
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// marshalWriteRequest encodes the series as prometheus.WriteRequest protobuf message:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func marshalWriteRequest(series []remoteWriteSeries) []byte {
+	var req []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.Labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.Name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.Value)
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		for _, smpl := range s.Samples {
+			var sample []byte
+			sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+			sample = protowire.AppendFixed64(sample, math.Float64bits(smpl.Value))
+			sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+			sample = protowire.AppendVarint(sample, uint64(smpl.Timestamp))
+
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, sample)
+		}
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
+
+// remoteWrite sends the metric families to a Prometheus remote-write 1.0 endpoint
+func remoteWrite(ctx context.Context, client httpDoer, url string, families []*dto.MetricFamily, external map[string]string) error {
+	body := snappy.Encode(nil, marshalWriteRequest(remoteWriteSeriesOf(families, external, time.Now())))
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "transmission-exporter/"+version)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remote write returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+
+	return nil
+}
@@ -1,46 +1,48 @@
 package main
 
 import (
-	"log"
+	"fmt"
 
-	"github.com/metalmatze/transmission-exporter"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// speedUnit converts Transmission's speed limits in kB/s to bytes
+const speedUnit = 1000
+
 // SessionCollector exposes session metrics
 type SessionCollector struct {
-	client *transmission.Client
-
-	AltSpeedDown     *prometheus.Desc
-	AltSpeedUp       *prometheus.Desc
+	AltSpeedDown     *renamedMetric
+	AltSpeedUp       *renamedMetric
 	CacheSize        *prometheus.Desc
-	FreeSpace        *prometheus.Desc
+	FreeSpace        *renamedMetric
 	QueueDown        *prometheus.Desc
 	QueueUp          *prometheus.Desc
 	PeerLimitGlobal  *prometheus.Desc
 	PeerLimitTorrent *prometheus.Desc
 	SeedRatioLimit   *prometheus.Desc
-	SpeedLimitDown   *prometheus.Desc
-	SpeedLimitUp     *prometheus.Desc
-	Version          *prometheus.Desc
+	SpeedLimitDown   *renamedMetric
+	SpeedLimitUp     *renamedMetric
+	Version          *renamedMetric
 }
 
-// NewSessionCollector takes a transmission.Client and returns a SessionCollector
-func NewSessionCollector(client *transmission.Client) *SessionCollector {
+// NewSessionCollector returns a SessionCollector
+func NewSessionCollector(naming Naming) *SessionCollector {
 	return &SessionCollector{
-		client: client,
-
-		AltSpeedDown: prometheus.NewDesc(
+		AltSpeedDown: newRenamedMetric(
+			naming,
 			namespace+"alt_speed_down",
+			namespace+"alt_speed_down_bytes_per_second",
+			prometheus.GaugeValue,
 			"Alternative max global download speed",
 			[]string{"enabled"},
-			nil,
 		),
-		AltSpeedUp: prometheus.NewDesc(
+		AltSpeedUp: newRenamedMetric(
+			naming,
 			namespace+"alt_speed_up",
+			namespace+"alt_speed_up_bytes_per_second",
+			prometheus.GaugeValue,
 			"Alternative max global upload speed",
 			[]string{"enabled"},
-			nil,
 		),
 		CacheSize: prometheus.NewDesc(
 			namespace+"cache_size_bytes",
@@ -48,11 +50,13 @@ func NewSessionCollector(client *transmission.Client) *SessionCollector {
 			nil,
 			nil,
 		),
-		FreeSpace: prometheus.NewDesc(
+		FreeSpace: newRenamedMetric(
+			naming,
 			namespace+"free_space",
+			namespace+"free_space_bytes",
+			prometheus.GaugeValue,
 			"Free space left on device to download to",
 			[]string{"download_dir", "incomplete_dir"},
-			nil,
 		),
 		QueueDown: prometheus.NewDesc(
 			namespace+"queue_down",
@@ -84,74 +88,69 @@ func NewSessionCollector(client *transmission.Client) *SessionCollector {
 			[]string{"enabled"},
 			nil,
 		),
-		SpeedLimitDown: prometheus.NewDesc(
+		SpeedLimitDown: newRenamedMetric(
+			naming,
 			namespace+"speed_limit_down_bytes",
+			namespace+"speed_limit_down_bytes_per_second",
+			prometheus.GaugeValue,
 			"Max global download speed",
 			[]string{"enabled"},
-			nil,
 		),
-		SpeedLimitUp: prometheus.NewDesc(
+		SpeedLimitUp: newRenamedMetric(
+			naming,
 			namespace+"speed_limit_up_bytes",
+			namespace+"speed_limit_up_bytes_per_second",
+			prometheus.GaugeValue,
 			"Max global upload speed",
 			[]string{"enabled"},
-			nil,
 		),
-		Version: prometheus.NewDesc(
+		Version: newRenamedMetric(
+			naming,
 			namespace+"version",
+			namespace+"version_info",
+			prometheus.GaugeValue,
 			"Transmission version as label",
 			[]string{"version"},
-			nil,
 		),
 	}
 }
 
 // Describe implements the prometheus.Collector interface
 func (sc *SessionCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- sc.AltSpeedDown
-	ch <- sc.AltSpeedUp
+	sc.AltSpeedDown.describe(ch)
+	sc.AltSpeedUp.describe(ch)
 	ch <- sc.CacheSize
-	ch <- sc.FreeSpace
+	sc.FreeSpace.describe(ch)
 	ch <- sc.QueueDown
 	ch <- sc.QueueUp
 	ch <- sc.PeerLimitGlobal
 	ch <- sc.PeerLimitTorrent
 	ch <- sc.SeedRatioLimit
-	ch <- sc.SpeedLimitDown
-	ch <- sc.SpeedLimitUp
-	ch <- sc.Version
+	sc.SpeedLimitDown.describe(ch)
+	sc.SpeedLimitUp.describe(ch)
+	sc.Version.describe(ch)
+}
+
+// RPCs implements the collector interface
+func (sc *SessionCollector) RPCs() []string {
+	return []string{sessionGet}
 }
 
-// Collect implements the prometheus.Collector interface
-func (sc *SessionCollector) Collect(ch chan<- prometheus.Metric) {
-	session, err := sc.client.GetSession()
-	if err != nil {
-		log.Printf("failed to get session: %v", err)
-		return
+// Update implements the collector interface
+func (sc *SessionCollector) Update(ch chan<- prometheus.Metric, s *snapshot) error {
+	if err := s.err(sessionGet); err != nil {
+		return fmt.Errorf("failed to get session: %v", err)
 	}
+	session := s.Session
 
-	ch <- prometheus.MustNewConstMetric(
-		sc.AltSpeedDown,
-		prometheus.GaugeValue,
-		float64(session.AltSpeedDown),
-		boolToString(session.AltSpeedEnabled),
-	)
-	ch <- prometheus.MustNewConstMetric(
-		sc.AltSpeedUp,
-		prometheus.GaugeValue,
-		float64(session.AltSpeedUp),
-		boolToString(session.AltSpeedEnabled),
-	)
+	sc.AltSpeedDown.collectValues(ch, float64(session.AltSpeedDown), float64(session.AltSpeedDown*speedUnit), boolToString(session.AltSpeedEnabled))
+	sc.AltSpeedUp.collectValues(ch, float64(session.AltSpeedUp), float64(session.AltSpeedUp*speedUnit), boolToString(session.AltSpeedEnabled))
 	ch <- prometheus.MustNewConstMetric(
 		sc.CacheSize,
 		prometheus.GaugeValue,
 		float64(session.CacheSizeMB*1024*1024),
 	)
-	ch <- prometheus.MustNewConstMetric(
-		sc.FreeSpace,
-		prometheus.GaugeValue,
-		float64(session.DownloadDirFreeSpace),
-		session.DownloadDir, session.IncompleteDir,
-	)
+	sc.FreeSpace.collect(ch, float64(session.DownloadDirFreeSpace), sanitizeLabel(session.DownloadDir, 0), sanitizeLabel(session.IncompleteDir, 0))
 	ch <- prometheus.MustNewConstMetric(
 		sc.QueueDown,
 		prometheus.GaugeValue,
@@ -180,22 +179,9 @@ func (sc *SessionCollector) Collect(ch chan<- prometheus.Metric) {
 		float64(session.SeedRatioLimit),
 		boolToString(session.SeedRatioLimited),
 	)
-	ch <- prometheus.MustNewConstMetric(
-		sc.SpeedLimitDown,
-		prometheus.GaugeValue,
-		float64(session.SpeedLimitDown),
-		boolToString(session.SpeedLimitDownEnabled),
-	)
-	ch <- prometheus.MustNewConstMetric(
-		sc.SpeedLimitUp,
-		prometheus.GaugeValue,
-		float64(session.SpeedLimitUp),
-		boolToString(session.SpeedLimitUpEnabled),
-	)
-	ch <- prometheus.MustNewConstMetric(
-		sc.Version,
-		prometheus.GaugeValue,
-		float64(1),
-		session.Version,
-	)
+	sc.SpeedLimitDown.collectValues(ch, float64(session.SpeedLimitDown), float64(session.SpeedLimitDown*speedUnit), boolToString(session.SpeedLimitDownEnabled))
+	sc.SpeedLimitUp.collectValues(ch, float64(session.SpeedLimitUp), float64(session.SpeedLimitUp*speedUnit), boolToString(session.SpeedLimitUpEnabled))
+	sc.Version.collect(ch, float64(1), sanitizeLabel(session.Version, 0))
+
+	return nil
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	transmission "github.com/metalmatze/transmission-exporter"
+)
+
+func TestSessionCollectorInvalidLabels(t *testing.T) {
+	src := &fakeSource{session: transmission.Session{
+		Version:              "4.0.5 (\xff\xfe)",
+		DownloadDir:          "/data/\xc3\x28downloads\n",
+		IncompleteDir:        "/data/incomplete",
+		DownloadDirFreeSpace: 1 << 30,
+	}}
+
+	// gather fails on label values that aren't valid UTF-8
+	families := gather(t, src, map[string]collector{"session": NewSessionCollector(NamingLegacy)})
+
+	tests := []struct {
+		metric string
+		labels map[string]string
+		want   float64
+	}{
+		{"transmission_version", map[string]string{"version": "4.0.5 (�)"}, 1},
+		{"transmission_free_space", map[string]string{"download_dir": "/data/�(downloads", "incomplete_dir": "/data/incomplete"}, 1 << 30},
+	}
+	for _, tt := range tests {
+		if got, ok := metricValue(families[tt.metric], tt.labels); !ok || got != tt.want {
+			t.Errorf("%s%v = %v, want %v", tt.metric, tt.labels, got, tt.want)
+		}
+	}
+}
@@ -1,7 +1,8 @@
 package main
 
 import (
-	"log"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/metalmatze/transmission-exporter"
@@ -10,45 +11,75 @@ import (
 
 // SessionStatsCollector exposes SessionStats as metrics
 type SessionStatsCollector struct {
-	client *transmission.Client
-
-	DownloadSpeed  *prometheus.Desc
-	UploadSpeed    *prometheus.Desc
-	TorrentsTotal  *prometheus.Desc
+	DownloadSpeed  *renamedMetric
+	UploadSpeed    *renamedMetric
+	TorrentsTotal  *renamedMetric
 	TorrentsActive *prometheus.Desc
 	TorrentsPaused *prometheus.Desc
 
-	Downloaded   *prometheus.Desc
-	Uploaded     *prometheus.Desc
-	FilesAdded   *prometheus.Desc
-	ActiveTime   *prometheus.Desc
-	SessionCount *prometheus.Desc
+	Downloaded   *renamedMetric
+	Uploaded     *renamedMetric
+	FilesAdded   *renamedMetric
+	ActiveTime   *renamedMetric
+	SessionCount *renamedMetric
+
+	start sessionStart
+}
+
+// sessionStart remembers when the current Transmission session started,
+// so its timestamp doesn't move between scrapes with the rounding of secondsActive
+type sessionStart struct {
+	mu            sync.Mutex
+	start         time.Time
+	sessions      int64
+	secondsActive int64
 }
 
-// NewSessionStatsCollector takes a transmission.Client and returns a SessionStatsCollector
-func NewSessionStatsCollector(client *transmission.Client) *SessionStatsCollector {
+// get returns the start of the current session,
+// which is only computed again once Transmission got restarted
+func (s *sessionStart) get(now time.Time, stats *transmission.SessionStats) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := stats.CumulativeStats.SessionCount
+	active := stats.CurrentStats.SecondsActive
+	if s.start.IsZero() || sessions != s.sessions || active < s.secondsActive {
+		s.start = now.Add(-time.Duration(active) * time.Second).Truncate(time.Second)
+		s.sessions = sessions
+	}
+	s.secondsActive = active
+
+	return s.start
+}
+
+// NewSessionStatsCollector returns a SessionStatsCollector
+func NewSessionStatsCollector(naming Naming) *SessionStatsCollector {
 	const collectorNamespace = "session_stats_"
 
 	return &SessionStatsCollector{
-		client: client,
-
-		DownloadSpeed: prometheus.NewDesc(
+		DownloadSpeed: newRenamedMetric(
+			naming,
 			namespace+collectorNamespace+"download_speed_bytes",
+			namespace+collectorNamespace+"download_bytes_per_second",
+			prometheus.GaugeValue,
 			"Current download speed in bytes",
 			nil,
-			nil,
 		),
-		UploadSpeed: prometheus.NewDesc(
+		UploadSpeed: newRenamedMetric(
+			naming,
 			namespace+collectorNamespace+"upload_speed_bytes",
+			namespace+collectorNamespace+"upload_bytes_per_second",
+			prometheus.GaugeValue,
 			"Current download speed in bytes",
 			nil,
-			nil,
 		),
-		TorrentsTotal: prometheus.NewDesc(
+		TorrentsTotal: newRenamedMetric(
+			naming,
 			namespace+collectorNamespace+"torrents_total",
+			namespace+collectorNamespace+"torrents",
+			prometheus.GaugeValue,
 			"The total number of torrents",
 			nil,
-			nil,
 		),
 		TorrentsActive: prometheus.NewDesc(
 			namespace+collectorNamespace+"torrents_active",
@@ -63,71 +94,78 @@ func NewSessionStatsCollector(client *transmission.Client) *SessionStatsCollecto
 			nil,
 		),
 
-		Downloaded: prometheus.NewDesc(
+		Downloaded: newRenamedMetric(
+			naming,
 			namespace+collectorNamespace+"downloaded_bytes",
+			namespace+collectorNamespace+"downloaded_bytes_total",
+			prometheus.CounterValue,
 			"The number of downloaded bytes",
 			[]string{"type"},
-			nil,
 		),
-		Uploaded: prometheus.NewDesc(
+		Uploaded: newRenamedMetric(
+			naming,
 			namespace+collectorNamespace+"uploaded_bytes",
+			namespace+collectorNamespace+"uploaded_bytes_total",
+			prometheus.CounterValue,
 			"The number of uploaded bytes",
 			[]string{"type"},
-			nil,
 		),
-		FilesAdded: prometheus.NewDesc(
+		FilesAdded: newRenamedMetric(
+			naming,
 			namespace+collectorNamespace+"files_added",
+			namespace+collectorNamespace+"files_added_total",
+			prometheus.CounterValue,
 			"The number of files added",
 			[]string{"type"},
-			nil,
 		),
-		ActiveTime: prometheus.NewDesc(
+		ActiveTime: newRenamedMetric(
+			naming,
 			namespace+collectorNamespace+"active",
+			namespace+collectorNamespace+"active_seconds_total",
+			prometheus.CounterValue,
 			"The time transmission is active since",
 			[]string{"type"},
-			nil,
 		),
-		SessionCount: prometheus.NewDesc(
+		SessionCount: newRenamedMetric(
+			naming,
 			namespace+collectorNamespace+"sessions",
+			namespace+collectorNamespace+"sessions_total",
+			prometheus.CounterValue,
 			"Count of the times transmission started",
 			[]string{"type"},
-			nil,
 		),
 	}
 }
 
 // Describe implements the prometheus.Collector interface
 func (sc *SessionStatsCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- sc.DownloadSpeed
-	ch <- sc.UploadSpeed
-	ch <- sc.TorrentsTotal
+	sc.DownloadSpeed.describe(ch)
+	sc.UploadSpeed.describe(ch)
+	sc.TorrentsTotal.describe(ch)
 	ch <- sc.TorrentsActive
 	ch <- sc.TorrentsPaused
+	sc.Downloaded.describe(ch)
+	sc.Uploaded.describe(ch)
+	sc.FilesAdded.describe(ch)
+	sc.ActiveTime.describe(ch)
+	sc.SessionCount.describe(ch)
 }
 
-// Collect implements the prometheus.Collector interface
-func (sc *SessionStatsCollector) Collect(ch chan<- prometheus.Metric) {
-	stats, err := sc.client.GetSessionStats()
-	if err != nil {
-		log.Printf("failed to get session stats: %v", err)
-		return
+// RPCs implements the collector interface
+func (sc *SessionStatsCollector) RPCs() []string {
+	return []string{sessionStats}
+}
+
+// Update implements the collector interface
+func (sc *SessionStatsCollector) Update(ch chan<- prometheus.Metric, s *snapshot) error {
+	if err := s.err(sessionStats); err != nil {
+		return fmt.Errorf("failed to get session stats: %v", err)
 	}
+	stats := s.SessionStats
 
-	ch <- prometheus.MustNewConstMetric(
-		sc.DownloadSpeed,
-		prometheus.GaugeValue,
-		float64(stats.DownloadSpeed),
-	)
-	ch <- prometheus.MustNewConstMetric(
-		sc.UploadSpeed,
-		prometheus.GaugeValue,
-		float64(stats.UploadSpeed),
-	)
-	ch <- prometheus.MustNewConstMetric(
-		sc.TorrentsTotal,
-		prometheus.GaugeValue,
-		float64(stats.TorrentCount),
-	)
+	sc.DownloadSpeed.collect(ch, float64(stats.DownloadSpeed))
+	sc.UploadSpeed.collect(ch, float64(stats.UploadSpeed))
+	sc.TorrentsTotal.collect(ch, float64(stats.TorrentCount))
 	ch <- prometheus.MustNewConstMetric(
 		sc.TorrentsActive,
 		prometheus.GaugeValue,
@@ -139,6 +177,7 @@ func (sc *SessionStatsCollector) Collect(ch chan<- prometheus.Metric) {
 		float64(stats.PausedTorrentCount),
 	)
 
+	now := time.Now()
 	types := []string{"current", "cumulative"}
 	for _, t := range types {
 		var stateStats transmission.SessionStateStats
@@ -148,39 +187,21 @@ func (sc *SessionStatsCollector) Collect(ch chan<- prometheus.Metric) {
 			stateStats = stats.CumulativeStats
 		}
 
-		ch <- prometheus.MustNewConstMetric(
-			sc.Downloaded,
-			prometheus.GaugeValue,
-			float64(stateStats.DownloadedBytes),
-			t,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			sc.Uploaded,
-			prometheus.GaugeValue,
-			float64(stateStats.UploadedBytes),
-			t,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			sc.FilesAdded,
-			prometheus.GaugeValue,
-			float64(stateStats.FilesAdded),
-			t,
-		)
-
-		dur := time.Duration(stateStats.SecondsActive) * time.Second
-		timestamp := time.Now().Add(-1 * dur).Unix()
+		// The current stats count since Transmission started, the cumulative ones since it was installed
+		var started, created time.Time
+		if t == types[0] {
+			started = sc.start.get(now, stats)
+			created = started
+		} else {
+			started = now.Add(-time.Duration(stateStats.SecondsActive) * time.Second)
+		}
 
-		ch <- prometheus.MustNewConstMetric(
-			sc.ActiveTime,
-			prometheus.GaugeValue,
-			float64(timestamp),
-			t,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			sc.SessionCount,
-			prometheus.GaugeValue,
-			float64(stateStats.SessionCount),
-			t,
-		)
+		sc.Downloaded.collectCreated(ch, float64(stateStats.DownloadedBytes), created, t)
+		sc.Uploaded.collectCreated(ch, float64(stateStats.UploadedBytes), created, t)
+		sc.FilesAdded.collectCreated(ch, float64(stateStats.FilesAdded), created, t)
+		sc.ActiveTime.collectValuesCreated(ch, float64(started.Unix()), float64(stateStats.SecondsActive), created, t)
+		sc.SessionCount.collectCreated(ch, float64(stateStats.SessionCount), created, t)
 	}
+
+	return nil
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	transmission "github.com/metalmatze/transmission-exporter"
+)
+
+func sessionStatsAt(sessions, secondsActive int64) *transmission.SessionStats {
+	var stats transmission.SessionStats
+	stats.CumulativeStats.SessionCount = sessions
+	stats.CurrentStats.SessionCount = 1
+	stats.CurrentStats.SecondsActive = secondsActive
+	return &stats
+}
+
+func TestSessionStart(t *testing.T) {
+	now := time.Unix(1700000000, 400*int64(time.Millisecond))
+	started := time.Unix(1700000000-100, 0)
+
+	var s sessionStart
+	steps := []struct {
+		name  string
+		now   time.Time
+		stats *transmission.SessionStats
+		want  time.Time
+	}{
+		{name: "first scrape", now: now, stats: sessionStatsAt(3, 100), want: started},
+		// secondsActive is rounded down, so it lags behind by up to a second
+		{name: "same session", now: now.Add(15 * time.Second), stats: sessionStatsAt(3, 114), want: started},
+		{name: "same session later", now: now.Add(time.Hour), stats: sessionStatsAt(3, 3700), want: started},
+		{name: "restarted", now: now.Add(2 * time.Hour), stats: sessionStatsAt(4, 10), want: now.Add(2*time.Hour - 10*time.Second).Truncate(time.Second)},
+		{name: "stats reset", now: now.Add(3 * time.Hour), stats: sessionStatsAt(4, 5), want: now.Add(3*time.Hour - 5*time.Second).Truncate(time.Second)},
+	}
+
+	for _, step := range steps {
+		if got := s.get(step.now, step.stats); !got.Equal(step.want) {
+			t.Errorf("%s: got session start %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestSessionStatsCollectorCreated(t *testing.T) {
+	src := &fakeSource{sessionStats: *sessionStatsAt(3, 100)}
+	collectors := map[string]collector{"session_stats": NewSessionStatsCollector(NamingV2)}
+
+	created := func() time.Time {
+		mf := gather(t, src, collectors)["transmission_session_stats_downloaded_bytes_total"]
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "type" && l.GetValue() == "current" {
+					return m.GetCounter().GetCreatedTimestamp().AsTime()
+				}
+			}
+		}
+		t.Fatal("no current downloaded bytes")
+		return time.Time{}
+	}
+
+	first := created()
+	src.sessionStats = *sessionStatsAt(3, 101)
+	time.Sleep(10 * time.Millisecond)
+	if second := created(); !second.Equal(first) {
+		t.Errorf("created timestamp moved from %v to %v between scrapes", first, second)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	transmission "github.com/metalmatze/transmission-exporter"
+)
+
+// The RPC methods a snapshot consists of
+const (
+	torrentGet   = "torrent-get"
+	sessionGet   = "session-get"
+	sessionStats = "session-stats"
+)
+
+// allRPCs are the methods of a complete snapshot
+var allRPCs = []string{torrentGet, sessionGet, sessionStats}
+
+var errDeadlineExceeded = errors.New("deadline exceeded")
+
+// snapshot holds everything fetched from Transmission for a single scrape,
+// so all collectors render from the same consistent state
+type snapshot struct {
+	Torrents     []transmission.Torrent
+	Session      *transmission.Session
+	SessionStats *transmission.SessionStats
+
+	// Fetches has the result of every RPC keyed by its method
+	Fetches map[string]fetchResult
+}
+
+// fetchResult is the outcome of a single RPC of a snapshot
+type fetchResult struct {
+	Duration time.Duration
+	Err      error
+}
+
+// err returns the error of the RPC, nil if it succeeded
+func (s *snapshot) err(method string) error {
+	return s.Fetches[method].Err
+}
+
+// fetched is sent by every RPC of fetchSnapshot once it returned
+type fetched struct {
+	method string
+	value  interface{}
+	result fetchResult
+}
+
+// fetchSnapshot calls the RPC methods in parallel, e.g. allRPCs.
+// RPCs not finished before the shared timeout fail and are canceled, the others are kept.
+func fetchSnapshot(src source, timeout time.Duration, methods []string) *snapshot {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	all := map[string]func(ctx context.Context) (interface{}, error){
+		torrentGet: func(ctx context.Context) (interface{}, error) {
+			return src.GetTorrents(ctx)
+		},
+		sessionGet: func(ctx context.Context) (interface{}, error) {
+			return src.GetSession(ctx)
+		},
+		sessionStats: func(ctx context.Context) (interface{}, error) {
+			return src.GetSessionStats(ctx)
+		},
+	}
+
+	fetches := make(map[string]func(ctx context.Context) (interface{}, error), len(methods))
+	for _, method := range methods {
+		fetches[method] = all[method]
+	}
+
+	start := time.Now()
+	// Buffered so RPCs returning after the deadline don't block forever
+	results := make(chan fetched, len(fetches))
+
+	for method, fetch := range fetches {
+		go func(method string, fetch func(ctx context.Context) (interface{}, error)) {
+			v, err := fetch(ctx)
+			results <- fetched{
+				method: method,
+				value:  v,
+				result: fetchResult{Duration: time.Since(start), Err: err},
+			}
+		}(method, fetch)
+	}
+
+	s := &snapshot{Fetches: make(map[string]fetchResult)}
+
+wait:
+	for len(s.Fetches) < len(fetches) {
+		select {
+		case f := <-results:
+			s.Fetches[f.method] = f.result
+			if f.result.Err != nil {
+				continue
+			}
+			switch v := f.value.(type) {
+			case []transmission.Torrent:
+				s.Torrents = v
+			case *transmission.Session:
+				s.Session = v
+			case *transmission.SessionStats:
+				s.SessionStats = v
+			}
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	for method := range fetches {
+		if _, ok := s.Fetches[method]; !ok {
+			s.Fetches[method] = fetchResult{Duration: time.Since(start), Err: errDeadlineExceeded}
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	transmission "github.com/metalmatze/transmission-exporter"
+)
+
+// hangingSource answers session-get and blocks torrent-get until canceled
+type hangingSource struct {
+	fakeSource
+	canceled chan struct{}
+}
+
+func (s *hangingSource) GetTorrents(ctx context.Context) ([]transmission.Torrent, error) {
+	<-ctx.Done()
+	close(s.canceled)
+	return nil, ctx.Err()
+}
+
+func TestFetchSnapshotTimeout(t *testing.T) {
+	src := &hangingSource{canceled: make(chan struct{})}
+
+	s := fetchSnapshot(src, 50*time.Millisecond, []string{torrentGet, sessionGet})
+	if err := s.err(sessionGet); err != nil {
+		t.Errorf("session-get failed: %v", err)
+	}
+	if err := s.err(torrentGet); err == nil {
+		t.Error("torrent-get didn't fail after the timeout")
+	}
+
+	select {
+	case <-src.canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("torrent-get wasn't canceled after the timeout")
+	}
+}
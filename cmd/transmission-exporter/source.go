@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	transmission "github.com/metalmatze/transmission-exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+)
+
+// source provides the data collectors export,
+// requests to Transmission are canceled with ctx
+type source interface {
+	GetTorrents(ctx context.Context) ([]transmission.Torrent, error)
+	GetSession(ctx context.Context) (*transmission.Session, error)
+	GetSessionStats(ctx context.Context) (*transmission.SessionStats, error)
+}
+
+// sharedSource fetches from Transmission on every call,
+// but concurrent callers share the in-flight requests.
+// The shared requests aren't canceled by the caller starting them,
+// only after timeout, while every caller stops waiting once its own context is done.
+type sharedSource struct {
+	client  *transmission.Client
+	timeout time.Duration
+	group   singleflight.Group
+}
+
+func newSharedSource(client *transmission.Client, timeout time.Duration) *sharedSource {
+	return &sharedSource{client: client, timeout: timeout}
+}
+
+// do runs fetch once for all concurrent callers of the same key
+func (s *sharedSource) do(ctx context.Context, key string, fetch func(context.Context) (interface{}, error)) (interface{}, error) {
+	ch := s.group.DoChan(key, func() (interface{}, error) {
+		ctx := context.WithoutCancel(ctx)
+		if s.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.timeout)
+			defer cancel()
+		}
+		return fetch(ctx)
+	})
+
+	select {
+	case res := <-ch:
+		return res.Val, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// GetTorrents implements the source interface
+func (s *sharedSource) GetTorrents(ctx context.Context) ([]transmission.Torrent, error) {
+	v, err := s.do(ctx, torrentGet, func(ctx context.Context) (interface{}, error) {
+		return s.client.GetTorrentsContext(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]transmission.Torrent), nil
+}
+
+// GetSession implements the source interface
+func (s *sharedSource) GetSession(ctx context.Context) (*transmission.Session, error) {
+	v, err := s.do(ctx, sessionGet, func(ctx context.Context) (interface{}, error) {
+		return s.client.GetSessionContext(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*transmission.Session), nil
+}
+
+// GetSessionStats implements the source interface
+func (s *sharedSource) GetSessionStats(ctx context.Context) (*transmission.SessionStats, error) {
+	v, err := s.do(ctx, sessionStats, func(ctx context.Context) (interface{}, error) {
+		return s.client.GetSessionStatsContext(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*transmission.SessionStats), nil
+}
+
+var errNoSnapshot = errors.New("no snapshot polled yet")
+
+// polled is the result of the last poll of a single RPC
+type polled struct {
+	value       interface{}
+	err         error
+	lastSuccess time.Time
+}
+
+// pollingSource polls Transmission in the background on an interval
+// and serves every call from the last snapshot
+type pollingSource struct {
+	logger   log.Logger
+	source   source
+	interval time.Duration
+	// timeout cancels a poll's RPCs, at most the interval
+	timeout time.Duration
+
+	mu       sync.RWMutex
+	snapshot map[string]*polled
+
+	SnapshotAge *prometheus.Desc
+}
+
+func newPollingSource(logger log.Logger, s source, interval, timeout time.Duration) *pollingSource {
+	const collectorNamespace = "exporter_"
+
+	if timeout <= 0 || timeout > interval {
+		timeout = interval
+	}
+
+	return &pollingSource{
+		logger:   logger,
+		source:   s,
+		interval: interval,
+		timeout:  timeout,
+		snapshot: make(map[string]*polled),
+
+		SnapshotAge: prometheus.NewDesc(
+			namespace+collectorNamespace+"snapshot_age_seconds",
+			"The age of the last successfully polled snapshot",
+			[]string{"method"},
+			nil,
+		),
+	}
+}
+
+// Run polls Transmission until stop is closed
+func (p *pollingSource) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.poll()
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// poll fetches all RPCs in parallel and updates the snapshot
+func (p *pollingSource) poll() {
+	s := fetchSnapshot(p.source, p.timeout, allRPCs)
+
+	for method, f := range s.Fetches {
+		if f.Err != nil {
+			level.Error(p.logger).Log("msg", "failed to poll", "method", method, "err", redact(f.Err.Error()))
+		}
+	}
+
+	p.update(torrentGet, s.Torrents, s.err(torrentGet))
+	p.update(sessionGet, s.Session, s.err(sessionGet))
+	p.update(sessionStats, s.SessionStats, s.err(sessionStats))
+}
+
+func (p *pollingSource) update(method string, v interface{}, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.snapshot[method]
+	if !ok {
+		s = &polled{}
+		p.snapshot[method] = s
+	}
+
+	s.err = err
+	if err == nil {
+		s.value = v
+		s.lastSuccess = time.Now()
+	}
+}
+
+// get returns the last polled value of the RPC,
+// or the error if its last poll failed
+func (p *pollingSource) get(method string) (interface{}, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	s, ok := p.snapshot[method]
+	if !ok {
+		return nil, errNoSnapshot
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.value, nil
+}
+
+// GetTorrents implements the source interface
+func (p *pollingSource) GetTorrents(ctx context.Context) ([]transmission.Torrent, error) {
+	v, err := p.get(torrentGet)
+	if err != nil {
+		return nil, err
+	}
+	return v.([]transmission.Torrent), nil
+}
+
+// GetSession implements the source interface
+func (p *pollingSource) GetSession(ctx context.Context) (*transmission.Session, error) {
+	v, err := p.get(sessionGet)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*transmission.Session), nil
+}
+
+// GetSessionStats implements the source interface
+func (p *pollingSource) GetSessionStats(ctx context.Context) (*transmission.SessionStats, error) {
+	v, err := p.get(sessionStats)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*transmission.SessionStats), nil
+}
+
+// Describe implements the prometheus.Collector interface
+func (p *pollingSource) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.SnapshotAge
+}
+
+// Collect implements the prometheus.Collector interface
+func (p *pollingSource) Collect(ch chan<- prometheus.Metric) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for method, s := range p.snapshot {
+		if s.lastSuccess.IsZero() {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			p.SnapshotAge,
+			prometheus.GaugeValue,
+			time.Since(s.lastSuccess).Seconds(),
+			method,
+		)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	transmission "github.com/metalmatze/transmission-exporter"
+)
+
+func TestSharedSourceCallerCanceled(t *testing.T) {
+	var requests atomic.Int32
+	received := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hand out the session token right away, only the RPCs block
+		if r.Header.Get("X-Transmission-Session-Id") == "" {
+			w.Header().Set("X-Transmission-Session-Id", "token")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if requests.Add(1) == 1 {
+			close(received)
+		}
+		<-release
+		w.Write([]byte(`{"arguments":{"version":"4.0.5"},"result":"success"}`))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	s := newSharedSource(transmission.New(srv.URL, nil), 10*time.Second)
+
+	// The first caller starts the shared request and gives up on it
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error)
+	go func() {
+		_, err := s.GetSession(first)
+		firstErr <- err
+	}()
+	<-received
+
+	type result struct {
+		session *transmission.Session
+		err     error
+	}
+	second := make(chan result)
+	go func() {
+		session, err := s.GetSession(context.Background())
+		second <- result{session, err}
+	}()
+
+	cancel()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("first caller got %v, want %v", err, context.Canceled)
+	}
+
+	release <- struct{}{}
+	select {
+	case res := <-second:
+		if res.err != nil {
+			t.Fatalf("second caller failed with the first caller's context: %v", res.err)
+		}
+		if res.session.Version != "4.0.5" {
+			t.Errorf("got version %q, want 4.0.5", res.session.Version)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second caller didn't get the shared request's result")
+	}
+
+	if n := requests.Load(); n != 1 {
+		t.Errorf("got %d requests, want 1 shared by both callers", n)
+	}
+}
+
+func TestSharedSourceTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	s := newSharedSource(transmission.New(srv.URL, nil), 50*time.Millisecond)
+
+	done := make(chan error)
+	go func() {
+		_, err := s.GetSession(context.Background())
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shared request wasn't canceled after the timeout")
+	}
+}
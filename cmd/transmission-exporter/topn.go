@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	transmission "github.com/metalmatze/transmission-exporter"
+)
+
+// otherLabel is the value of all identity labels of the catch-all series
+const otherLabel = "__other__"
+
+// Transmission reports these instead of an upload ratio
+const (
+	ratioNA  = -1
+	ratioInf = -2
+)
+
+// topScore calculates the score torrents are ranked by in top N mode.
+// Previously selected torrents get stickiness added to their score,
+// so torrents with similar scores don't flap in and out of the top N.
+type topScore struct {
+	score      func(t transmission.Torrent) float64
+	stickiness float64
+}
+
+var topScores = map[string]topScore{
+	"upload": {
+		score:      func(t transmission.Torrent) float64 { return float64(t.RateUpload) },
+		stickiness: 16 * 1024,
+	},
+	"download": {
+		score:      func(t transmission.Torrent) float64 { return float64(t.RateDownload) },
+		stickiness: 16 * 1024,
+	},
+	"ratio": {
+		score:      ratioScore,
+		stickiness: 0.1,
+	},
+}
+
+// ratioScore ranks torrents uploaded without downloading first
+// and torrents without a ratio like nothing was uploaded
+func ratioScore(t transmission.Torrent) float64 {
+	switch t.UploadRatio {
+	case ratioInf:
+		return math.Inf(1)
+	case ratioNA:
+		return 0
+	default:
+		return t.UploadRatio
+	}
+}
+
+// validateTopBy returns an error if torrents can't be ranked by the given score
+func validateTopBy(by string) error {
+	if _, ok := topScores[by]; !ok {
+		return fmt.Errorf("unknown top N score %q, valid scores are upload, download and ratio", by)
+	}
+	return nil
+}
+
+// topSelector selects the top N torrents and remembers them between scrapes
+type topSelector struct {
+	n     int
+	score topScore
+
+	mu       sync.Mutex
+	selected map[string]bool
+}
+
+func newTopSelector(n int, by string) *topSelector {
+	score, ok := topScores[by]
+	if !ok {
+		score = topScores["upload"]
+	}
+
+	return &topSelector{
+		n:        n,
+		score:    score,
+		selected: make(map[string]bool),
+	}
+}
+
+// Select splits the torrents into the top N and the other torrents.
+// Ties are broken by previous selection first and hash second to be stable.
+func (s *topSelector) Select(torrents []transmission.Torrent) (top, other []transmission.Torrent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(torrents) <= s.n {
+		s.remember(torrents)
+		return torrents, nil
+	}
+
+	type ranked struct {
+		torrent   transmission.Torrent
+		score     float64
+		incumbent bool
+	}
+
+	ranking := make([]ranked, len(torrents))
+	for i, t := range torrents {
+		r := ranked{torrent: t, score: s.score.score(t), incumbent: s.selected[t.HashString]}
+		if r.incumbent {
+			r.score += s.score.stickiness
+		}
+		ranking[i] = r
+	}
+
+	sort.Slice(ranking, func(i, j int) bool {
+		a, b := ranking[i], ranking[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if a.incumbent != b.incumbent {
+			return a.incumbent
+		}
+		return a.torrent.HashString < b.torrent.HashString
+	})
+
+	for i, r := range ranking {
+		if i < s.n {
+			top = append(top, r.torrent)
+		} else {
+			other = append(other, r.torrent)
+		}
+	}
+	s.remember(top)
+
+	return top, other
+}
+
+func (s *topSelector) remember(torrents []transmission.Torrent) {
+	s.selected = make(map[string]bool, len(torrents))
+	for _, t := range torrents {
+		s.selected[t.HashString] = true
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+
+	transmission "github.com/metalmatze/transmission-exporter"
+)
+
+func hashes(torrents []transmission.Torrent) []string {
+	var hs []string
+	for _, t := range torrents {
+		hs = append(hs, t.HashString)
+	}
+	return hs
+}
+
+func equalHashes(got []transmission.Torrent, want []string) bool {
+	hs := hashes(got)
+	if len(hs) != len(want) {
+		return false
+	}
+	for i := range hs {
+		if hs[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTopSelectorRatio(t *testing.T) {
+	torrents := []transmission.Torrent{
+		{HashString: "a", UploadRatio: 1.5},
+		{HashString: "b", UploadRatio: ratioNA},
+		{HashString: "c", UploadRatio: ratioInf},
+		{HashString: "d", UploadRatio: 0.5},
+	}
+
+	top, other := newTopSelector(2, "ratio").Select(torrents)
+	if !equalHashes(top, []string{"c", "a"}) {
+		t.Errorf("top = %v, want [c a]", hashes(top))
+	}
+	if !equalHashes(other, []string{"d", "b"}) {
+		t.Errorf("other = %v, want [d b]", hashes(other))
+	}
+}
+
+func TestTopSelectorStickiness(t *testing.T) {
+	tests := []struct {
+		name  string
+		by    string
+		first []transmission.Torrent
+		then  []transmission.Torrent
+		want  []string
+	}{
+		{
+			name:  "idle torrent stays within margin",
+			by:    "upload",
+			first: []transmission.Torrent{{HashString: "a", RateUpload: 0}, {HashString: "b", RateUpload: 0}},
+			then:  []transmission.Torrent{{HashString: "a", RateUpload: 0}, {HashString: "b", RateUpload: 1024}},
+			want:  []string{"a"},
+		},
+		{
+			name:  "idle torrent replaced beyond margin",
+			by:    "upload",
+			first: []transmission.Torrent{{HashString: "a", RateUpload: 0}, {HashString: "b", RateUpload: 0}},
+			then:  []transmission.Torrent{{HashString: "a", RateUpload: 0}, {HashString: "b", RateUpload: 32 * 1024}},
+			want:  []string{"b"},
+		},
+		{
+			name:  "torrent stays within margin",
+			by:    "download",
+			first: []transmission.Torrent{{HashString: "a", RateDownload: 1 << 20}, {HashString: "b", RateDownload: 0}},
+			then:  []transmission.Torrent{{HashString: "a", RateDownload: 1 << 20}, {HashString: "b", RateDownload: 1<<20 + 1024}},
+			want:  []string{"a"},
+		},
+		{
+			name:  "torrent without ratio stays within margin",
+			by:    "ratio",
+			first: []transmission.Torrent{{HashString: "a", UploadRatio: ratioNA}, {HashString: "b", UploadRatio: ratioNA}},
+			then:  []transmission.Torrent{{HashString: "a", UploadRatio: ratioNA}, {HashString: "b", UploadRatio: 0.05}},
+			want:  []string{"a"},
+		},
+		{
+			name:  "torrent without ratio replaced beyond margin",
+			by:    "ratio",
+			first: []transmission.Torrent{{HashString: "a", UploadRatio: ratioNA}, {HashString: "b", UploadRatio: ratioNA}},
+			then:  []transmission.Torrent{{HashString: "a", UploadRatio: ratioNA}, {HashString: "b", UploadRatio: 0.2}},
+			want:  []string{"b"},
+		},
+		{
+			name:  "infinite ratio isn't sticky beyond another one",
+			by:    "ratio",
+			first: []transmission.Torrent{{HashString: "a", UploadRatio: ratioInf}, {HashString: "b", UploadRatio: 2}},
+			then:  []transmission.Torrent{{HashString: "a", UploadRatio: 2}, {HashString: "b", UploadRatio: ratioInf}},
+			want:  []string{"b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTopSelector(1, tt.by)
+			s.Select(tt.first)
+			if top, _ := s.Select(tt.then); !equalHashes(top, tt.want) {
+				t.Errorf("top = %v, want %v", hashes(top), tt.want)
+			}
+		})
+	}
+}
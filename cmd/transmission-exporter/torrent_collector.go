@@ -1,9 +1,14 @@
 package main
 
 import (
-	"log"
+	"fmt"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	transmission "github.com/metalmatze/transmission-exporter"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -12,126 +17,425 @@ const (
 	namespace string = "transmission_"
 )
 
+// TorrentOptions configure which torrent metrics are exported
+type TorrentOptions struct {
+	// FileMetrics enables the per-file metrics of every torrent
+	FileMetrics bool
+	// PieceMetrics enables the metrics based on the pieces bitfield,
+	// which requires the client to request the piece fields
+	PieceMetrics bool
+	// ErrorMessageLength truncates error messages to the number of characters
+	ErrorMessageLength int
+	// Labels identify a torrent, any combination of hash, id and name
+	Labels []string
+	// NameMaxLength truncates torrent names to the number of characters
+	NameMaxLength int
+	// MaxSeries limits the number of per-torrent series, 0 means unlimited
+	MaxSeries int
+	// Filter selects the torrents per-torrent metrics are exported for
+	Filter *TorrentFilter
+	// TopN only exports per-torrent metrics for the top N torrents
+	// and sums up the others, 0 exports all torrents
+	TopN int
+	// TopBy is the score torrents are ranked by, upload, download or ratio
+	TopBy string
+	// Naming selects the names of renamed metrics
+	Naming Naming
+	// Logger logs torrents exceeding MaxSeries and undecodable pieces
+	Logger log.Logger
+}
+
 // TorrentCollector has a transmission.Client to create torrent metrics
 type TorrentCollector struct {
-	client *transmission.Client
+	opts TorrentOptions
+	top  *topSelector
 
 	Status             *prometheus.Desc
-	Added              *prometheus.Desc
-	Files              *prometheus.Desc
+	Added              *renamedMetric
+	Files              *renamedMetric
 	Finished           *prometheus.Desc
-	Done               *prometheus.Desc
+	Done               *renamedMetric
 	Ratio              *prometheus.Desc
-	Download           *prometheus.Desc
-	Upload             *prometheus.Desc
+	Download           *renamedMetric
+	Upload             *renamedMetric
 	PeersConnected     *prometheus.Desc
 	PeersGettingFromUs *prometheus.Desc
-	TotalSize          *prometheus.Desc
-	UploadedEver       *prometheus.Desc
+	TotalSize          *renamedMetric
+	UploadedEver       *renamedMetric
+
+	// Top N
+	OtherTorrents *prometheus.Desc
+
+	// Errors
+	Error           *prometheus.Desc
+	ErrorInfo       *prometheus.Desc
+	ErroredTorrents *prometheus.Desc
+
+	// Pieces
+	Pieces           *prometheus.Desc
+	PiecesHave       *prometheus.Desc
+	PieceSize        *prometheus.Desc
+	DesiredAvailable *prometheus.Desc
+	Availability     *prometheus.Desc
+	HaveValid        *prometheus.Desc
+	HaveUnchecked    *prometheus.Desc
+	Corrupt          *renamedMetric
+
+	// Files
+	WantedBytes   *prometheus.Desc
+	SkippedFiles  *prometheus.Desc
+	PriorityFiles *prometheus.Desc
+	FileCompleted *prometheus.Desc
+	FileLength    *prometheus.Desc
+	FilePriority  *prometheus.Desc
+	FileWanted    *prometheus.Desc
 
 	// TrackerStats
-	Downloads *prometheus.Desc
-	Leechers  *prometheus.Desc
-	Seeders   *prometheus.Desc
+	Downloads             *renamedMetric
+	Leechers              *prometheus.Desc
+	Seeders               *prometheus.Desc
+	TierDownloads         *prometheus.Desc
+	TierLeechers          *prometheus.Desc
+	TierSeeders           *prometheus.Desc
+	AnnounceSuccess       *prometheus.Desc
+	ScrapeSuccess         *prometheus.Desc
+	LastAnnounce          *prometheus.Desc
+	LastScrape            *prometheus.Desc
+	NextAnnounce          *prometheus.Desc
+	AnnounceTimedOut      *prometheus.Desc
+	ScrapeTimedOut        *prometheus.Desc
+	LastAnnouncePeerCount *prometheus.Desc
+	Backup                *prometheus.Desc
+	FailingTorrents       *prometheus.Desc
 }
 
-// NewTorrentCollector creates a new torrent collector with the transmission.Client
-func NewTorrentCollector(client *transmission.Client) *TorrentCollector {
+// NewTorrentCollector creates a new torrent collector
+func NewTorrentCollector(opts TorrentOptions) *TorrentCollector {
 	const collectorNamespace = "torrent_"
 
+	labels := opts.Labels
+	if len(labels) == 0 {
+		labels = []string{"id", "name"}
+		opts.Labels = labels
+	}
+
+	var top *topSelector
+	if opts.TopN > 0 {
+		top = newTopSelector(opts.TopN, opts.TopBy)
+	}
+
 	return &TorrentCollector{
-		client: client,
+		opts: opts,
+		top:  top,
 
 		Status: prometheus.NewDesc(
 			namespace+collectorNamespace+"status",
 			"Status of a torrent",
-			[]string{"id", "name"},
+			labels,
 			nil,
 		),
-		Added: prometheus.NewDesc(
+		Added: newRenamedMetric(
+			opts.Naming,
 			namespace+collectorNamespace+"added",
+			namespace+collectorNamespace+"added_timestamp_seconds",
+			prometheus.GaugeValue,
 			"The unixtime time a torrent was added",
-			[]string{"id", "name"},
-			nil,
+			labels,
 		),
-		Files: prometheus.NewDesc(
+		Files: newRenamedMetric(
+			opts.Naming,
 			namespace+collectorNamespace+"files_total",
+			namespace+collectorNamespace+"files",
+			prometheus.GaugeValue,
 			"The total number of files in a torrent",
-			[]string{"id", "name"},
-			nil,
+			labels,
 		),
 		Finished: prometheus.NewDesc(
 			namespace+collectorNamespace+"finished",
 			"Indicates if a torrent is finished (1) or not (0)",
-			[]string{"id", "name"},
+			labels,
 			nil,
 		),
-		Done: prometheus.NewDesc(
+		Done: newRenamedMetric(
+			opts.Naming,
 			namespace+collectorNamespace+"done",
+			namespace+collectorNamespace+"done_ratio",
+			prometheus.GaugeValue,
 			"The percent of a torrent being done",
-			[]string{"id", "name"},
-			nil,
+			labels,
 		),
 		Ratio: prometheus.NewDesc(
 			namespace+collectorNamespace+"ratio",
 			"The upload ratio of a torrent",
-			[]string{"id", "name"},
+			labels,
 			nil,
 		),
-		Download: prometheus.NewDesc(
+		Download: newRenamedMetric(
+			opts.Naming,
 			namespace+collectorNamespace+"download_bytes",
+			namespace+collectorNamespace+"download_bytes_per_second",
+			prometheus.GaugeValue,
 			"The current download rate of a torrent in bytes",
-			[]string{"id", "name"},
-			nil,
+			labels,
 		),
-		Upload: prometheus.NewDesc(
+		Upload: newRenamedMetric(
+			opts.Naming,
 			namespace+collectorNamespace+"upload_bytes",
+			namespace+collectorNamespace+"upload_bytes_per_second",
+			prometheus.GaugeValue,
 			"The current upload rate of a torrent in bytes",
-			[]string{"id", "name"},
-			nil,
+			labels,
 		),
 		PeersConnected: prometheus.NewDesc(
 			namespace+collectorNamespace+"peers_connected",
 			"The current number of peers connected to us",
-			[]string{"id", "name"},
+			labels,
 			nil,
 		),
 		PeersGettingFromUs: prometheus.NewDesc(
 			namespace+collectorNamespace+"peers_getting_from_us",
 			"The current number of peers downloading from us",
-			[]string{"id", "name"},
+			labels,
 			nil,
 		),
-		TotalSize: prometheus.NewDesc(
+		TotalSize: newRenamedMetric(
+			opts.Naming,
 			namespace+collectorNamespace+"total_size",
+			namespace+collectorNamespace+"size_bytes",
+			prometheus.GaugeValue,
 			"The total size of the torrent",
-			[]string{"id", "name"},
-			nil,
+			labels,
 		),
-		UploadedEver: prometheus.NewDesc(
+		UploadedEver: newRenamedMetric(
+			opts.Naming,
 			namespace+collectorNamespace+"uploaded_ever",
+			namespace+collectorNamespace+"uploaded_bytes_total",
+			prometheus.CounterValue,
 			"The total uploaded of the torrent",
-			[]string{"id", "name"},
+			labels,
+		),
+
+		// Top N
+		OtherTorrents: prometheus.NewDesc(
+			namespace+collectorNamespace+"other_torrents",
+			"The number of torrents outside of the top N summed up in the "+otherLabel+" series",
+			nil,
+			nil,
+		),
+
+		// Errors
+		Error: prometheus.NewDesc(
+			namespace+collectorNamespace+"error",
+			"The error class of a torrent, none (0), tracker warning (1), tracker error (2) or local error (3)",
+			labels,
+			nil,
+		),
+		ErrorInfo: prometheus.NewDesc(
+			namespace+collectorNamespace+"error_info",
+			"The error of a torrent with class and message as labels",
+			withLabels(labels, "class", "message"),
+			nil,
+		),
+		ErroredTorrents: prometheus.NewDesc(
+			namespace+"errored_torrents",
+			"The number of torrents with an error by class",
+			[]string{"class"},
+			nil,
+		),
+
+		// Pieces
+		Pieces: prometheus.NewDesc(
+			namespace+collectorNamespace+"pieces",
+			"The number of pieces of a torrent",
+			labels,
+			nil,
+		),
+		PiecesHave: prometheus.NewDesc(
+			namespace+collectorNamespace+"pieces_have",
+			"The number of pieces of a torrent we have",
+			labels,
+			nil,
+		),
+		PieceSize: prometheus.NewDesc(
+			namespace+collectorNamespace+"piece_size_bytes",
+			"The size of a single piece of a torrent",
+			labels,
+			nil,
+		),
+		DesiredAvailable: prometheus.NewDesc(
+			namespace+collectorNamespace+"desired_available_bytes",
+			"The number of bytes still wanted that connected peers can provide",
+			labels,
+			nil,
+		),
+		Availability: prometheus.NewDesc(
+			namespace+collectorNamespace+"missing_available_ratio",
+			"The share of the missing pieces of wanted files connected peers have, below 1 the torrent can't finish with the current peers",
+			labels,
+			nil,
+		),
+		HaveValid: prometheus.NewDesc(
+			namespace+collectorNamespace+"have_valid_bytes",
+			"The number of bytes verified against the piece hashes",
+			labels,
+			nil,
+		),
+		HaveUnchecked: prometheus.NewDesc(
+			namespace+collectorNamespace+"have_unchecked_bytes",
+			"The number of bytes downloaded but not yet verified",
+			labels,
+			nil,
+		),
+		Corrupt: newRenamedMetric(
+			opts.Naming,
+			namespace+collectorNamespace+"corrupt_bytes",
+			namespace+collectorNamespace+"corrupt_bytes_total",
+			prometheus.CounterValue,
+			"The number of corrupt bytes downloaded for a torrent",
+			labels,
+		),
+
+		// Files
+		WantedBytes: prometheus.NewDesc(
+			namespace+collectorNamespace+"wanted_bytes",
+			"The total size of the wanted files of a torrent",
+			labels,
+			nil,
+		),
+		SkippedFiles: prometheus.NewDesc(
+			namespace+collectorNamespace+"skipped_files",
+			"The number of files of a torrent that are not wanted",
+			labels,
+			nil,
+		),
+		PriorityFiles: prometheus.NewDesc(
+			namespace+collectorNamespace+"priority_files",
+			"The number of files of a torrent by priority",
+			withLabels(labels, "priority"),
+			nil,
+		),
+		FileCompleted: prometheus.NewDesc(
+			namespace+collectorNamespace+"file_completed_bytes",
+			"The number of completed bytes of a file",
+			[]string{"hash", "file"},
+			nil,
+		),
+		FileLength: prometheus.NewDesc(
+			namespace+collectorNamespace+"file_length_bytes",
+			"The size of a file",
+			[]string{"hash", "file"},
+			nil,
+		),
+		FilePriority: prometheus.NewDesc(
+			namespace+collectorNamespace+"file_priority",
+			"The priority of a file, low (-1), normal (0) or high (1)",
+			[]string{"hash", "file"},
+			nil,
+		),
+		FileWanted: prometheus.NewDesc(
+			namespace+collectorNamespace+"file_wanted",
+			"Indicates if a file is wanted (1) or not (0)",
+			[]string{"hash", "file"},
 			nil,
 		),
 
 		// TrackerStats
-		Downloads: prometheus.NewDesc(
+		Downloads: newRenamedMetric(
+			opts.Naming,
 			namespace+collectorNamespace+"downloads_total",
+			namespace+collectorNamespace+"tracker_downloads",
+			prometheus.GaugeValue,
 			"How often this torrent was downloaded",
-			[]string{"id", "name", "tracker"},
-			nil,
+			withLabels(labels, "tracker"),
 		),
 		Leechers: prometheus.NewDesc(
 			namespace+collectorNamespace+"leechers",
 			"The number of peers downloading this torrent",
-			[]string{"id", "name", "tracker"},
+			withLabels(labels, "tracker"),
 			nil,
 		),
 		Seeders: prometheus.NewDesc(
 			namespace+collectorNamespace+"seeders",
 			"The number of peers uploading this torrent",
-			[]string{"id", "name", "tracker"},
+			withLabels(labels, "tracker"),
+			nil,
+		),
+		TierDownloads: prometheus.NewDesc(
+			namespace+collectorNamespace+"tracker_tier_downloads",
+			"How often this torrent was downloaded according to the trackers of a tier",
+			withLabels(labels, "tracker", "tier"),
+			nil,
+		),
+		TierLeechers: prometheus.NewDesc(
+			namespace+collectorNamespace+"tracker_tier_leechers",
+			"The number of peers downloading this torrent according to the trackers of a tier",
+			withLabels(labels, "tracker", "tier"),
+			nil,
+		),
+		TierSeeders: prometheus.NewDesc(
+			namespace+collectorNamespace+"tracker_tier_seeders",
+			"The number of peers uploading this torrent according to the trackers of a tier",
+			withLabels(labels, "tracker", "tier"),
+			nil,
+		),
+		AnnounceSuccess: prometheus.NewDesc(
+			namespace+collectorNamespace+"tracker_announce_success",
+			"Indicates if the last announce to the tracker succeeded (1) or not (0)",
+			withLabels(labels, "tracker", "tier"),
+			nil,
+		),
+		ScrapeSuccess: prometheus.NewDesc(
+			namespace+collectorNamespace+"tracker_scrape_success",
+			"Indicates if the last scrape of the tracker succeeded (1) or not (0)",
+			withLabels(labels, "tracker", "tier"),
+			nil,
+		),
+		LastAnnounce: prometheus.NewDesc(
+			namespace+collectorNamespace+"tracker_last_announce_timestamp_seconds",
+			"The unixtime of the last announce to the tracker",
+			withLabels(labels, "tracker", "tier"),
+			nil,
+		),
+		LastScrape: prometheus.NewDesc(
+			namespace+collectorNamespace+"tracker_last_scrape_timestamp_seconds",
+			"The unixtime of the last scrape of the tracker",
+			withLabels(labels, "tracker", "tier"),
+			nil,
+		),
+		NextAnnounce: prometheus.NewDesc(
+			namespace+collectorNamespace+"tracker_next_announce_timestamp_seconds",
+			"The unixtime of the next announce to the tracker",
+			withLabels(labels, "tracker", "tier"),
+			nil,
+		),
+		AnnounceTimedOut: prometheus.NewDesc(
+			namespace+collectorNamespace+"tracker_announce_timed_out",
+			"Indicates if the last announce to the tracker timed out (1) or not (0)",
+			withLabels(labels, "tracker", "tier"),
+			nil,
+		),
+		ScrapeTimedOut: prometheus.NewDesc(
+			namespace+collectorNamespace+"tracker_scrape_timed_out",
+			"Indicates if the last scrape of the tracker timed out (1) or not (0)",
+			withLabels(labels, "tracker", "tier"),
+			nil,
+		),
+		LastAnnouncePeerCount: prometheus.NewDesc(
+			namespace+collectorNamespace+"tracker_last_announce_peers",
+			"The number of peers the tracker returned on the last announce",
+			withLabels(labels, "tracker", "tier"),
+			nil,
+		),
+		Backup: prometheus.NewDesc(
+			namespace+collectorNamespace+"tracker_backup",
+			"Indicates if the tracker is only used as backup (1) or not (0)",
+			withLabels(labels, "tracker", "tier"),
+			nil,
+		),
+		FailingTorrents: prometheus.NewDesc(
+			namespace+"tracker_announce_failing_torrents",
+			"The number of torrents whose last announce to the tracker failed",
+			[]string{"tracker"},
 			nil,
 		),
 	}
@@ -140,143 +444,645 @@ func NewTorrentCollector(client *transmission.Client) *TorrentCollector {
 // Describe implements the prometheus.Collector interface
 func (tc *TorrentCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- tc.Status
-	ch <- tc.Added
-	ch <- tc.Files
+	tc.Added.describe(ch)
+	tc.Files.describe(ch)
 	ch <- tc.Finished
-	ch <- tc.Done
+	tc.Done.describe(ch)
 	ch <- tc.Ratio
-	ch <- tc.Download
-	ch <- tc.Upload
-	ch <- tc.Downloads
+	tc.Download.describe(ch)
+	tc.Upload.describe(ch)
+	tc.Downloads.describe(ch)
 	ch <- tc.Leechers
 	ch <- tc.Seeders
+	ch <- tc.TierDownloads
+	ch <- tc.TierLeechers
+	ch <- tc.TierSeeders
+	ch <- tc.AnnounceSuccess
+	ch <- tc.ScrapeSuccess
+	ch <- tc.LastAnnounce
+	ch <- tc.LastScrape
+	ch <- tc.NextAnnounce
+	ch <- tc.AnnounceTimedOut
+	ch <- tc.ScrapeTimedOut
+	ch <- tc.LastAnnouncePeerCount
+	ch <- tc.Backup
+	ch <- tc.FailingTorrents
 	ch <- tc.PeersConnected
 	ch <- tc.PeersGettingFromUs
-	ch <- tc.TotalSize
-	ch <- tc.UploadedEver
+	tc.TotalSize.describe(ch)
+	tc.UploadedEver.describe(ch)
+	if tc.top != nil {
+		ch <- tc.OtherTorrents
+	}
+	ch <- tc.Error
+	ch <- tc.ErrorInfo
+	ch <- tc.ErroredTorrents
+	ch <- tc.Pieces
+	ch <- tc.PieceSize
+	ch <- tc.DesiredAvailable
+	ch <- tc.HaveValid
+	ch <- tc.HaveUnchecked
+	tc.Corrupt.describe(ch)
+	ch <- tc.WantedBytes
+	ch <- tc.SkippedFiles
+	ch <- tc.PriorityFiles
+
+	if tc.opts.PieceMetrics {
+		ch <- tc.PiecesHave
+		ch <- tc.Availability
+	}
+
+	if tc.opts.FileMetrics {
+		ch <- tc.FileCompleted
+		ch <- tc.FileLength
+		ch <- tc.FilePriority
+		ch <- tc.FileWanted
+	}
 }
 
-// Collect implements the prometheus.Collector interface
-func (tc *TorrentCollector) Collect(ch chan<- prometheus.Metric) {
-	torrents, err := tc.client.GetTorrents()
-	if err != nil {
-		log.Printf("failed to get torrents: %v", err)
-		return
+// RPCs implements the collector interface
+func (tc *TorrentCollector) RPCs() []string {
+	return []string{torrentGet}
+}
+
+// Update implements the collector interface
+func (tc *TorrentCollector) Update(ch chan<- prometheus.Metric, s *snapshot) error {
+	if err := s.err(torrentGet); err != nil {
+		return fmt.Errorf("failed to get torrents: %v", err)
+	}
+	torrents := s.Torrents
+
+	failingTorrents := make(map[string]int)
+	erroredTorrents := make(map[string]int)
+	for _, class := range errorClasses {
+		erroredTorrents[class] = 0
 	}
 
+	var selected []transmission.Torrent
+
 	for _, t := range torrents {
-		var finished float64
+		if !tc.opts.Filter.MatchAggregate(t) {
+			continue
+		}
 
-		id := strconv.Itoa(t.ID)
+		if class, ok := errorClasses[t.Error]; ok {
+			erroredTorrents[class]++
+		}
 
-		if t.IsFinished {
-			finished = 1
+		failing := make(map[string]bool)
+		for _, tier := range trackerTiers(t.TrackerStats) {
+			if _, ok := failingTorrents[tier.Host]; !ok {
+				failingTorrents[tier.Host] = 0
+			}
+			if tier.AnnounceFailing() && !failing[tier.Host] {
+				failing[tier.Host] = true
+				failingTorrents[tier.Host]++
+			}
 		}
 
+		if tc.opts.Filter.Match(t) {
+			selected = append(selected, t)
+		}
+	}
+
+	if tc.top != nil {
+		var other []transmission.Torrent
+		selected, other = tc.top.Select(selected)
+		tc.collectOther(ch, other)
+	}
+
+	// Oldest first, so the torrents added last are skipped once the series limit is reached
+	sort.Slice(selected, func(i, j int) bool {
+		if selected[i].Added != selected[j].Added {
+			return selected[i].Added < selected[j].Added
+		}
+		return selected[i].HashString < selected[j].HashString
+	})
+
+	var series, skipped, duplicates int
+	exported := make(map[string]bool, len(selected))
+	if tc.top != nil {
+		exported[strings.Join(tc.otherLabelValues(), "\xff")] = true
+	}
+
+	for n, t := range selected {
+		// Without hash or id, e.g. TORRENT_LABELS=name, torrents can share their label values
+		key := strings.Join(tc.labelValues(t), "\xff")
+		if exported[key] {
+			duplicates++
+			continue
+		}
+
+		metrics := tc.torrentMetrics(t, trackerTiers(t.TrackerStats))
+		if tc.opts.MaxSeries > 0 && series+len(metrics) > tc.opts.MaxSeries {
+			// Stop at the first torrent exceeding the limit, so later smaller ones don't take turns with it
+			skipped = len(selected) - n
+			break
+		}
+		series += len(metrics)
+		exported[key] = true
+
+		for _, m := range metrics {
+			ch <- m
+		}
+	}
+
+	if skipped > 0 {
+		level.Warn(tc.opts.Logger).Log("msg", "skipped torrents exceeding the series limit", "skipped", skipped, "max_series", tc.opts.MaxSeries)
+	}
+	if duplicates > 0 {
+		level.Warn(tc.opts.Logger).Log("msg", "skipped torrents with the same label values as another torrent, add the hash or id label", "skipped", duplicates, "labels", strings.Join(tc.opts.Labels, ","))
+	}
+
+	for class, count := range erroredTorrents {
 		ch <- prometheus.MustNewConstMetric(
-			tc.Status,
-			prometheus.GaugeValue,
-			float64(t.Status),
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.Added,
-			prometheus.GaugeValue,
-			float64(t.Added),
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.Files,
-			prometheus.GaugeValue,
-			float64(len(t.Files)),
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.Finished,
-			prometheus.GaugeValue,
-			finished,
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.Done,
-			prometheus.GaugeValue,
-			t.PercentDone,
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.Ratio,
-			prometheus.GaugeValue,
-			t.UploadRatio,
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.Download,
-			prometheus.GaugeValue,
-			float64(t.RateDownload),
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.Upload,
-			prometheus.GaugeValue,
-			float64(t.RateUpload),
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.PeersConnected,
-			prometheus.GaugeValue,
-			float64(t.PeersConnected),
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.PeersGettingFromUs,
-			prometheus.GaugeValue,
-			float64(t.PeersGettingFromUs),
-			id, t.Name,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			tc.TotalSize,
+			tc.ErroredTorrents,
 			prometheus.GaugeValue,
-			float64(t.TotalSize),
-			id, t.Name,
+			float64(count),
+			class,
 		)
+	}
+
+	for host, count := range failingTorrents {
 		ch <- prometheus.MustNewConstMetric(
-			tc.UploadedEver,
+			tc.FailingTorrents,
 			prometheus.GaugeValue,
-			float64(t.UploadedEver),
-			id, t.Name,
+			float64(count),
+			host,
 		)
+	}
+
+	return nil
+}
+
+// collectOther sums up the torrents outside of the top N into one series per metric
+func (tc *TorrentCollector) collectOther(ch chan<- prometheus.Metric, torrents []transmission.Torrent) {
+	labels := tc.otherLabelValues()
+
+	var sum transmission.Torrent
+	var files int
+	for _, t := range torrents {
+		sum.RateDownload += t.RateDownload
+		sum.RateUpload += t.RateUpload
+		sum.PeersConnected += t.PeersConnected
+		sum.PeersGettingFromUs += t.PeersGettingFromUs
+		sum.TotalSize += t.TotalSize
+		sum.UploadedEver += t.UploadedEver
+		files += len(t.Files)
+	}
 
-		tstats := make(map[string]transmission.TrackerStat)
+	ch <- prometheus.MustNewConstMetric(
+		tc.OtherTorrents,
+		prometheus.GaugeValue,
+		float64(len(torrents)),
+	)
+	tc.Files.collect(ch, float64(files), labels...)
+	tc.Download.collect(ch, float64(sum.RateDownload), labels...)
+	tc.Upload.collect(ch, float64(sum.RateUpload), labels...)
+	ch <- prometheus.MustNewConstMetric(
+		tc.PeersConnected,
+		prometheus.GaugeValue,
+		float64(sum.PeersConnected),
+		labels...,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		tc.PeersGettingFromUs,
+		prometheus.GaugeValue,
+		float64(sum.PeersGettingFromUs),
+		labels...,
+	)
+	tc.TotalSize.collect(ch, float64(sum.TotalSize), labels...)
+	tc.UploadedEver.collect(ch, float64(sum.UploadedEver), labels...)
+}
+
+// otherLabelValues returns the values of the identity labels of the other torrents' sum
+func (tc *TorrentCollector) otherLabelValues() []string {
+	values := make([]string, len(tc.opts.Labels))
+	for i := range values {
+		values[i] = otherLabel
+	}
+	return values
+}
+
+// labelValues returns the values of the identity labels of a torrent
+func (tc *TorrentCollector) labelValues(t transmission.Torrent) []string {
+	values := make([]string, 0, len(tc.opts.Labels))
+	for _, l := range tc.opts.Labels {
+		switch l {
+		case "hash":
+			values = append(values, t.HashString)
+		case "id":
+			values = append(values, strconv.Itoa(t.ID))
+		case "name":
+			values = append(values, sanitizeLabel(t.Name, tc.opts.NameMaxLength))
+		}
+	}
+	return values
+}
 
-		for _, tracker := range t.TrackerStats {
-			if tr, exists := tstats[tracker.Host]; exists {
-				tr.DownloadCount += tracker.DownloadCount
-			} else {
-				tstats[tracker.Host] = tracker
+// metricSlice collects the metrics of a single torrent,
+// so they're counted against the series limit before any of them is sent
+type metricSlice []prometheus.Metric
+
+func (s *metricSlice) add(m prometheus.Metric) {
+	*s = append(*s, m)
+}
+
+// addRenamed adds the value under the enabled names, created is the start of v2 counters if known
+func (s *metricSlice) addRenamed(m *renamedMetric, value float64, created time.Time, labels ...string) {
+	*s = append(*s, m.metrics(value, value, created, labels...)...)
+}
+
+// torrentMetrics returns all metrics of a single torrent
+func (tc *TorrentCollector) torrentMetrics(t transmission.Torrent, tiers []*trackerTier) []prometheus.Metric {
+	var metrics metricSlice
+	tc.collectTorrent(&metrics, t, tiers)
+	return metrics
+}
+
+func (tc *TorrentCollector) collectTorrent(metrics *metricSlice, t transmission.Torrent, tiers []*trackerTier) {
+	var finished float64
+	if t.IsFinished {
+		finished = 1
+	}
+
+	labels := tc.labelValues(t)
+
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.Status,
+		prometheus.GaugeValue,
+		float64(t.Status),
+		labels...,
+	))
+	metrics.addRenamed(tc.Added, float64(t.Added), time.Time{}, labels...)
+	metrics.addRenamed(tc.Files, float64(len(t.Files)), time.Time{}, labels...)
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.Finished,
+		prometheus.GaugeValue,
+		finished,
+		labels...,
+	))
+	metrics.addRenamed(tc.Done, t.PercentDone, time.Time{}, labels...)
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.Ratio,
+		prometheus.GaugeValue,
+		t.UploadRatio,
+		labels...,
+	))
+	metrics.addRenamed(tc.Download, float64(t.RateDownload), time.Time{}, labels...)
+	metrics.addRenamed(tc.Upload, float64(t.RateUpload), time.Time{}, labels...)
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.PeersConnected,
+		prometheus.GaugeValue,
+		float64(t.PeersConnected),
+		labels...,
+	))
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.PeersGettingFromUs,
+		prometheus.GaugeValue,
+		float64(t.PeersGettingFromUs),
+		labels...,
+	))
+	metrics.addRenamed(tc.TotalSize, float64(t.TotalSize), time.Time{}, labels...)
+	metrics.addRenamed(tc.UploadedEver, float64(t.UploadedEver), torrentAdded(t), labels...)
+
+	tc.collectError(metrics, t, labels)
+	tc.collectPieces(metrics, t, labels)
+	tc.collectFiles(metrics, t, labels)
+
+	for _, host := range trackerHosts(t.TrackerStats) {
+		tc.collectTrackerHost(metrics, host, labels)
+	}
+	for _, tier := range tiers {
+		tc.collectTracker(metrics, tier, labels)
+	}
+}
+
+// collectTrackerHost exports the swarm counts of a tracker host like before tiers were exported
+func (tc *TorrentCollector) collectTrackerHost(metrics *metricSlice, host *trackerTier, labels []string) {
+	labels = withLabels(labels, host.Host)
+
+	metrics.addRenamed(tc.Downloads, float64(host.Downloads), time.Time{}, labels...)
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.Leechers,
+		prometheus.GaugeValue,
+		float64(host.Leechers),
+		labels...,
+	))
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.Seeders,
+		prometheus.GaugeValue,
+		float64(host.Seeders),
+		labels...,
+	))
+}
+
+func (tc *TorrentCollector) collectTracker(metrics *metricSlice, tier *trackerTier, labels []string) {
+	labels = withLabels(labels, tier.Host, tier.TierLabel())
+
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.TierDownloads,
+		prometheus.GaugeValue,
+		float64(tier.Downloads),
+		labels...,
+	))
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.TierLeechers,
+		prometheus.GaugeValue,
+		float64(tier.Leechers),
+		labels...,
+	))
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.TierSeeders,
+		prometheus.GaugeValue,
+		float64(tier.Seeders),
+		labels...,
+	))
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.AnnounceSuccess,
+		prometheus.GaugeValue,
+		boolToFloat(tier.AnnounceSucceeded),
+		labels...,
+	))
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.ScrapeSuccess,
+		prometheus.GaugeValue,
+		boolToFloat(tier.ScrapeSucceeded),
+		labels...,
+	))
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.LastAnnounce,
+		prometheus.GaugeValue,
+		float64(tier.LastAnnounceTime),
+		labels...,
+	))
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.LastScrape,
+		prometheus.GaugeValue,
+		float64(tier.LastScrapeTime),
+		labels...,
+	))
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.NextAnnounce,
+		prometheus.GaugeValue,
+		float64(tier.NextAnnounceTime),
+		labels...,
+	))
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.AnnounceTimedOut,
+		prometheus.GaugeValue,
+		boolToFloat(tier.AnnounceTimedOut),
+		labels...,
+	))
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.ScrapeTimedOut,
+		prometheus.GaugeValue,
+		boolToFloat(tier.ScrapeTimedOut),
+		labels...,
+	))
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.LastAnnouncePeerCount,
+		prometheus.GaugeValue,
+		float64(tier.LastAnnouncePeerCount),
+		labels...,
+	))
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.Backup,
+		prometheus.GaugeValue,
+		boolToFloat(tier.IsBackup),
+		labels...,
+	))
+}
+
+// errorClasses maps a torrent's error to the class label value
+var errorClasses = map[int]string{
+	1: "tracker_warning",
+	2: "tracker_error",
+	3: "local_error",
+}
+
+func (tc *TorrentCollector) collectError(metrics *metricSlice, t transmission.Torrent, labels []string) {
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.Error,
+		prometheus.GaugeValue,
+		float64(t.Error),
+		labels...,
+	))
+
+	class, ok := errorClasses[t.Error]
+	if !ok {
+		return
+	}
+
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.ErrorInfo,
+		prometheus.GaugeValue,
+		1,
+		withLabels(labels, class, sanitizeLabel(t.ErrorString, tc.opts.ErrorMessageLength))...,
+	))
+}
+
+// torrentAdded returns the time the torrent was added, zero if unknown
+func torrentAdded(t transmission.Torrent) time.Time {
+	if t.Added <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(t.Added), 0)
+}
+
+func (tc *TorrentCollector) collectPieces(metrics *metricSlice, t transmission.Torrent, labels []string) {
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.Pieces,
+		prometheus.GaugeValue,
+		float64(t.PieceCount),
+		labels...,
+	))
+
+	if tc.opts.PieceMetrics {
+		tc.collectPieceAvailability(metrics, t, labels)
+	}
+
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.PieceSize,
+		prometheus.GaugeValue,
+		float64(t.PieceSize),
+		labels...,
+	))
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.DesiredAvailable,
+		prometheus.GaugeValue,
+		float64(t.DesiredAvailable),
+		labels...,
+	))
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.HaveValid,
+		prometheus.GaugeValue,
+		float64(t.HaveValid),
+		labels...,
+	))
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.HaveUnchecked,
+		prometheus.GaugeValue,
+		float64(t.HaveUnchecked),
+		labels...,
+	))
+	metrics.addRenamed(tc.Corrupt, float64(t.CorruptEver), torrentAdded(t), labels...)
+}
+
+// collectPieceAvailability exports the pieces we have and the share of
+// the missing ones connected peers have, which Transmission reports since 4.0
+func (tc *TorrentCollector) collectPieceAvailability(metrics *metricSlice, t transmission.Torrent, labels []string) {
+	bitfield, err := t.PiecesBitfield()
+	if err != nil {
+		level.Warn(tc.opts.Logger).Log("msg", "failed to decode pieces", "torrent", t.HashString, "err", err)
+		return
+	}
+
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.PiecesHave,
+		prometheus.GaugeValue,
+		float64(bitfield.Count(t.PieceCount)),
+		labels...,
+	))
+
+	if len(t.Availability) != t.PieceCount {
+		return
+	}
+
+	missing, available := missingPieces(t, bitfield)
+
+	// Nothing is missing once everything wanted is downloaded
+	ratio := 1.0
+	if missing > 0 {
+		ratio = float64(available) / float64(missing)
+	}
+
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.Availability,
+		prometheus.GaugeValue,
+		ratio,
+		labels...,
+	))
+}
+
+// missingPieces returns the number of pieces of wanted files missing in the bitfield
+// and how many of them at least one connected peer has according to the availability
+func missingPieces(t transmission.Torrent, bitfield transmission.Bitfield) (missing, available int) {
+	wanted := wantedPieces(t)
+	for i := 0; i < t.PieceCount; i++ {
+		if !wanted[i] || bitfield.Has(i) {
+			continue
+		}
+		missing++
+		if i < len(t.Availability) && t.Availability[i] > 0 {
+			available++
+		}
+	}
+	return missing, available
+}
+
+// wantedPieces marks the pieces overlapping wanted files. All pieces are wanted
+// without file stats, e.g. for magnet links without metadata.
+func wantedPieces(t transmission.Torrent) []bool {
+	wanted := make([]bool, t.PieceCount)
+	if len(t.FilesStats) != len(t.Files) || t.PieceSize <= 0 {
+		for i := range wanted {
+			wanted[i] = true
+		}
+		return wanted
+	}
+
+	// Files are stored back to back, a piece can span the end of one and the start of the next file
+	var offset int64
+	for i, f := range t.Files {
+		if t.FilesStats[i].Wanted && f.Length > 0 {
+			last := (offset + f.Length - 1) / t.PieceSize
+			for p := offset / t.PieceSize; p <= last && p < int64(len(wanted)); p++ {
+				wanted[p] = true
 			}
 		}
+		offset += f.Length
+	}
+	return wanted
+}
 
-		for _, tracker := range tstats {
-			ch <- prometheus.MustNewConstMetric(
-				tc.Downloads,
-				prometheus.GaugeValue,
-				float64(tracker.DownloadCount),
-				id, t.Name, tracker.Host,
-			)
-
-			ch <- prometheus.MustNewConstMetric(
-				tc.Leechers,
-				prometheus.GaugeValue,
-				float64(tracker.LeecherCount),
-				id, t.Name, tracker.Host,
-			)
-
-			ch <- prometheus.MustNewConstMetric(
-				tc.Seeders,
-				prometheus.GaugeValue,
-				float64(tracker.SeederCount),
-				id, t.Name, tracker.Host,
-			)
+// filePriorities maps a file's priority to the label value
+var filePriorities = map[int]string{
+	-1: "low",
+	0:  "normal",
+	1:  "high",
+}
+
+func (tc *TorrentCollector) collectFiles(metrics *metricSlice, t transmission.Torrent, labels []string) {
+	var wanted int64
+	var skipped int
+	priorities := map[string]int{"low": 0, "normal": 0, "high": 0}
+
+	for i, f := range t.Files {
+		// fileStats may be missing, e.g. for magnet links without metadata
+		if i >= len(t.FilesStats) {
+			break
+		}
+		stat := t.FilesStats[i]
+		file := sanitizeLabel(f.Name, 0)
+
+		if stat.Wanted {
+			wanted += f.Length
+		} else {
+			skipped++
 		}
+		if p, ok := filePriorities[stat.Priority]; ok {
+			priorities[p]++
+		}
+
+		if !tc.opts.FileMetrics {
+			continue
+		}
+
+		metrics.add(prometheus.MustNewConstMetric(
+			tc.FileCompleted,
+			prometheus.GaugeValue,
+			float64(f.BytesCompleted),
+			t.HashString, file,
+		))
+		metrics.add(prometheus.MustNewConstMetric(
+			tc.FileLength,
+			prometheus.GaugeValue,
+			float64(f.Length),
+			t.HashString, file,
+		))
+		metrics.add(prometheus.MustNewConstMetric(
+			tc.FilePriority,
+			prometheus.GaugeValue,
+			float64(stat.Priority),
+			t.HashString, file,
+		))
+		metrics.add(prometheus.MustNewConstMetric(
+			tc.FileWanted,
+			prometheus.GaugeValue,
+			boolToFloat(stat.Wanted),
+			t.HashString, file,
+		))
+	}
+
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.WantedBytes,
+		prometheus.GaugeValue,
+		float64(wanted),
+		labels...,
+	))
+	metrics.add(prometheus.MustNewConstMetric(
+		tc.SkippedFiles,
+		prometheus.GaugeValue,
+		float64(skipped),
+		labels...,
+	))
+	for p, count := range priorities {
+		metrics.add(prometheus.MustNewConstMetric(
+			tc.PriorityFiles,
+			prometheus.GaugeValue,
+			float64(count),
+			withLabels(labels, p)...,
+		))
 	}
 }
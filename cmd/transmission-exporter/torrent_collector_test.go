@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/go-kit/log"
+	transmission "github.com/metalmatze/transmission-exporter"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// pieceTorrent has 5 pieces of 10 bytes and three files:
+// a.bin in pieces 0-2 and not wanted, b.bin in piece 2 and c.bin in pieces 3-4.
+// We have piece 3, peers have pieces 1 and 4.
+func pieceTorrent() transmission.Torrent {
+	return transmission.Torrent{
+		ID:         1,
+		Name:       "pieces",
+		HashString: "aaaa",
+		PieceCount: 5,
+		PieceSize:  10,
+		Pieces:     base64.StdEncoding.EncodeToString([]byte{0x10}),
+		Files: []transmission.File{
+			{Name: "a.bin", Length: 25},
+			{Name: "b.bin", Length: 5},
+			{Name: "c.bin", Length: 20},
+		},
+		FilesStats: []transmission.FileStat{
+			{Wanted: false},
+			{Wanted: true},
+			{Wanted: true},
+		},
+		Availability: []int{0, 2, 0, -1, 1},
+	}
+}
+
+func TestWantedPieces(t *testing.T) {
+	got := wantedPieces(pieceTorrent())
+	want := []bool{false, false, true, true, true}
+	if len(got) != len(want) {
+		t.Fatalf("got %d pieces, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("piece %d wanted = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// Without file stats, e.g. magnet links, all pieces are wanted
+	torrent := pieceTorrent()
+	torrent.FilesStats = nil
+	for i, wanted := range wantedPieces(torrent) {
+		if !wanted {
+			t.Errorf("piece %d of torrent without file stats isn't wanted", i)
+		}
+	}
+}
+
+func TestMissingPieces(t *testing.T) {
+	torrent := pieceTorrent()
+	bitfield, err := torrent.PiecesBitfield()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pieces 2 and 4 are missing, only 4 is available
+	missing, available := missingPieces(torrent, bitfield)
+	if missing != 2 || available != 1 {
+		t.Errorf("missingPieces() = %d, %d, want 2, 1", missing, available)
+	}
+}
+
+func TestTorrentCollectorPieceMetrics(t *testing.T) {
+	complete := pieceTorrent()
+	complete.ID = 2
+	complete.HashString = "bbbb"
+	complete.Pieces = base64.StdEncoding.EncodeToString([]byte{0xf8})
+	complete.Availability = []int{-1, -1, -1, -1, -1}
+
+	// Transmission before 4.0 doesn't report the availability
+	old := pieceTorrent()
+	old.ID = 3
+	old.HashString = "cccc"
+	old.Availability = nil
+
+	src := &fakeSource{torrents: []transmission.Torrent{pieceTorrent(), complete, old}}
+
+	families := gather(t, src, map[string]collector{
+		"torrent": NewTorrentCollector(TorrentOptions{PieceMetrics: true, Logger: log.NewNopLogger()}),
+	})
+
+	tests := []struct {
+		metric string
+		id     string
+		want   float64
+		absent bool
+	}{
+		{metric: "transmission_torrent_pieces_have", id: "1", want: 1},
+		{metric: "transmission_torrent_pieces_have", id: "2", want: 5},
+		{metric: "transmission_torrent_pieces_have", id: "3", want: 1},
+		{metric: "transmission_torrent_missing_available_ratio", id: "1", want: 0.5},
+		{metric: "transmission_torrent_missing_available_ratio", id: "2", want: 1},
+		{metric: "transmission_torrent_missing_available_ratio", id: "3", absent: true},
+	}
+	for _, tt := range tests {
+		got, ok := metricValue(families[tt.metric], map[string]string{"id": tt.id})
+		if tt.absent {
+			if ok {
+				t.Errorf("%s{id=%q} = %v, want none", tt.metric, tt.id, got)
+			}
+			continue
+		}
+		if !ok || got != tt.want {
+			t.Errorf("%s{id=%q} = %v, want %v", tt.metric, tt.id, got, tt.want)
+		}
+	}
+
+	// The metrics need the pieces, which are only requested with piece metrics
+	families = gather(t, src, map[string]collector{
+		"torrent": NewTorrentCollector(TorrentOptions{Logger: log.NewNopLogger()}),
+	})
+	for _, name := range []string{"transmission_torrent_pieces_have", "transmission_torrent_missing_available_ratio"} {
+		if _, ok := families[name]; ok {
+			t.Errorf("%s is exported without piece metrics", name)
+		}
+	}
+}
+
+func TestTorrentCollectorCorruptCounter(t *testing.T) {
+	src := &fakeSource{torrents: []transmission.Torrent{{ID: 1, Name: "corrupt", Added: 1600000000, CorruptEver: 4096}}}
+
+	families := gather(t, src, map[string]collector{
+		"torrent": NewTorrentCollector(TorrentOptions{Naming: NamingBoth, Logger: log.NewNopLogger()}),
+	})
+
+	legacy := families["transmission_torrent_corrupt_bytes"]
+	if legacy.GetType() != dto.MetricType_GAUGE {
+		t.Errorf("transmission_torrent_corrupt_bytes is a %s, want a gauge", legacy.GetType())
+	}
+	counter := families["transmission_torrent_corrupt_bytes_total"]
+	if counter.GetType() != dto.MetricType_COUNTER {
+		t.Fatalf("transmission_torrent_corrupt_bytes_total is a %s, want a counter", counter.GetType())
+	}
+	c := counter.GetMetric()[0].GetCounter()
+	if c.GetValue() != 4096 {
+		t.Errorf("transmission_torrent_corrupt_bytes_total = %v, want 4096", c.GetValue())
+	}
+	if c.GetCreatedTimestamp().GetSeconds() != 1600000000 {
+		t.Errorf("created timestamp = %v, want the time the torrent was added", c.GetCreatedTimestamp().AsTime())
+	}
+}
+
+func TestTorrentCollectorDuplicateLabels(t *testing.T) {
+	torrents := []transmission.Torrent{
+		{ID: 3, Name: "ubuntu-24.04-desktop-amd64.iso", HashString: "cccc", Added: 300},
+		{ID: 1, Name: "ubuntu-24.04-server-amd64.iso", HashString: "aaaa", Added: 100},
+		{ID: 2, Name: "ubuntu-24.04-server-amd64.iso", HashString: "bbbb", Added: 200},
+	}
+
+	tests := []struct {
+		name       string
+		opts       TorrentOptions
+		wantLabels []string
+	}{
+		{
+			name:       "same names",
+			opts:       TorrentOptions{Labels: []string{"name"}},
+			wantLabels: []string{"ubuntu-24.04-desktop-amd64.iso", "ubuntu-24.04-server-amd64.iso"},
+		},
+		{
+			name:       "same truncated names",
+			opts:       TorrentOptions{Labels: []string{"name"}, NameMaxLength: 12},
+			wantLabels: []string{"ubuntu-24.04…"},
+		},
+		{
+			name:       "unique with id",
+			opts:       TorrentOptions{Labels: []string{"id", "name"}, NameMaxLength: 12},
+			wantLabels: []string{"1", "2", "3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Logger = log.NewNopLogger()
+			// gather fails on series with the same labels
+			families := gather(t, &fakeSource{torrents: torrents}, map[string]collector{
+				"torrent": NewTorrentCollector(tt.opts),
+			})
+
+			got := families["transmission_torrent_status"].GetMetric()
+			if len(got) != len(tt.wantLabels) {
+				t.Fatalf("got %d series, want %d", len(got), len(tt.wantLabels))
+			}
+			for i, m := range got {
+				if v := m.GetLabel()[0].GetValue(); v != tt.wantLabels[i] {
+					t.Errorf("series %d has label %q, want %q", i, v, tt.wantLabels[i])
+				}
+			}
+
+			// The oldest of the torrents with the same name is exported
+			if tt.opts.NameMaxLength > 0 && len(tt.opts.Labels) == 1 {
+				if v, _ := metricValue(families["transmission_torrent_added"], nil); v != 100 {
+					t.Errorf("exported the torrent added at %v, want the oldest one", v)
+				}
+			}
+		})
+	}
+}
+
+func TestTorrentCollectorMaxSeries(t *testing.T) {
+	small := func(id, added int) transmission.Torrent {
+		return transmission.Torrent{ID: id, Name: "small", HashString: string(rune('a' + id)), Added: added}
+	}
+	large := small(2, 200)
+	large.TrackerStats = []transmission.TrackerStat{
+		{Host: "tracker.example.org:443", Tier: 0},
+		{Host: "backup.example.net:6969", Tier: 1},
+	}
+
+	tc := NewTorrentCollector(TorrentOptions{Logger: log.NewNopLogger()})
+	smallSeries := len(tc.torrentMetrics(small(1, 100), nil))
+	largeSeries := len(tc.torrentMetrics(large, trackerTiers(large.TrackerStats)))
+	if largeSeries <= smallSeries {
+		t.Fatalf("large torrent has %d series, small ones %d", largeSeries, smallSeries)
+	}
+
+	// Room for both small torrents, but not the large one added in between
+	tc = NewTorrentCollector(TorrentOptions{MaxSeries: 2*smallSeries + 1, Logger: log.NewNopLogger()})
+	families := gather(t, &fakeSource{torrents: []transmission.Torrent{small(3, 300), large, small(1, 100)}}, map[string]collector{
+		"torrent": tc,
+	})
+
+	got := families["transmission_torrent_status"].GetMetric()
+	if len(got) != 1 {
+		t.Fatalf("got %d torrents, want only the one added before the large torrent", len(got))
+	}
+	if id := got[0].GetLabel()[0].GetValue(); id != "1" {
+		t.Errorf("exported torrent %s, want 1", id)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+
+	transmission "github.com/metalmatze/transmission-exporter"
+)
+
+// trackerTier aggregates the TrackerStats of a torrent sharing the same host and tier,
+// or only the same host if aggregated by trackerHosts
+type trackerTier struct {
+	Host string
+	Tier int
+
+	// Counts are -1 if none of the trackers reported them
+	Downloads             int
+	Leechers              int
+	Seeders               int
+	LastAnnouncePeerCount int
+
+	HasAnnounced      bool
+	AnnounceSucceeded bool
+	AnnounceTimedOut  bool
+	HasScraped        bool
+	ScrapeSucceeded   bool
+	ScrapeTimedOut    bool
+	IsBackup          bool
+
+	LastAnnounceTime int
+	LastScrapeTime   int
+	NextAnnounceTime int
+}
+
+// TierLabel returns the tier as label value
+func (t *trackerTier) TierLabel() string {
+	return strconv.Itoa(t.Tier)
+}
+
+// AnnounceFailing is true if the last announce of an active tracker didn't succeed
+func (t *trackerTier) AnnounceFailing() bool {
+	return !t.IsBackup && t.HasAnnounced && !t.AnnounceSucceeded
+}
+
+func (t *trackerTier) add(s transmission.TrackerStat) {
+	// Trackers of the same host report the same swarm, so summing would count peers twice
+	t.Downloads = maxCount(t.Downloads, s.DownloadCount)
+	t.Leechers = maxCount(t.Leechers, s.LeecherCount)
+	t.Seeders = maxCount(t.Seeders, s.SeederCount)
+	t.LastAnnouncePeerCount = maxCount(t.LastAnnouncePeerCount, s.LastAnnouncePeerCount)
+
+	// One succeeding tracker of a tier is enough, as they're used interchangeably
+	t.HasAnnounced = t.HasAnnounced || s.HasAnnounced
+	t.AnnounceSucceeded = t.AnnounceSucceeded || s.LastAnnounceSucceeded
+	t.AnnounceTimedOut = t.AnnounceTimedOut || s.LastAnnounceTimedOut
+	t.HasScraped = t.HasScraped || s.HasScraped
+	t.ScrapeSucceeded = t.ScrapeSucceeded || s.LastScrapeSucceeded
+	t.ScrapeTimedOut = t.ScrapeTimedOut || s.LastScrapeTimedOut
+	t.IsBackup = t.IsBackup && s.IsBackup
+
+	if s.LastAnnounceTime > t.LastAnnounceTime {
+		t.LastAnnounceTime = s.LastAnnounceTime
+	}
+	if s.LastScrapeTime > t.LastScrapeTime {
+		t.LastScrapeTime = s.LastScrapeTime
+	}
+	if s.NextAnnounceTime > 0 && (t.NextAnnounceTime == 0 || s.NextAnnounceTime < t.NextAnnounceTime) {
+		t.NextAnnounceTime = s.NextAnnounceTime
+	}
+}
+
+// maxCount returns the larger of two counts that Transmission reports as -1 when unknown
+func maxCount(a, b int) int {
+	if b > a {
+		return b
+	}
+	return a
+}
+
+// trackerTiers aggregates a torrent's TrackerStats by host and tier
+func trackerTiers(stats []transmission.TrackerStat) []*trackerTier {
+	return aggregateTrackers(stats, true)
+}
+
+// trackerHosts aggregates a torrent's TrackerStats by host only, the tier is always 0
+func trackerHosts(stats []transmission.TrackerStat) []*trackerTier {
+	return aggregateTrackers(stats, false)
+}
+
+func aggregateTrackers(stats []transmission.TrackerStat, byTier bool) []*trackerTier {
+	type key struct {
+		host string
+		tier int
+	}
+
+	tiers := make(map[key]*trackerTier)
+	for _, s := range stats {
+		k := key{host: s.Host}
+		if byTier {
+			k.tier = s.Tier
+		}
+		t, ok := tiers[k]
+		if !ok {
+			t = &trackerTier{
+				Host:                  s.Host,
+				Tier:                  k.tier,
+				Downloads:             -1,
+				Leechers:              -1,
+				Seeders:               -1,
+				LastAnnouncePeerCount: -1,
+				IsBackup:              true,
+			}
+			tiers[k] = t
+		}
+		t.add(s)
+	}
+
+	out := make([]*trackerTier, 0, len(tiers))
+	for _, t := range tiers {
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Tier != out[j].Tier {
+			return out[i].Tier < out[j].Tier
+		}
+		return out[i].Host < out[j].Host
+	})
+
+	return out
+}
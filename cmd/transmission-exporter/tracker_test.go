@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	transmission "github.com/metalmatze/transmission-exporter"
+)
+
+func TestTrackerAggregation(t *testing.T) {
+	stats := []transmission.TrackerStat{
+		{Host: "tracker.example.org:443", Tier: 0, SeederCount: 10, LeecherCount: 3, DownloadCount: 100},
+		// The same swarm announced over UDP
+		{Host: "tracker.example.org:443", Tier: 0, SeederCount: 12, LeecherCount: 2, DownloadCount: 90},
+		{Host: "tracker.example.org:443", Tier: 1, SeederCount: -1, LeecherCount: -1, DownloadCount: -1},
+		{Host: "backup.example.net:6969", Tier: 1, SeederCount: 4, LeecherCount: 1, DownloadCount: 7},
+	}
+
+	type counts struct {
+		host                         string
+		tier                         int
+		seeders, leechers, downloads int
+	}
+	countsOf := func(tiers []*trackerTier) []counts {
+		var out []counts
+		for _, t := range tiers {
+			out = append(out, counts{t.Host, t.Tier, t.Seeders, t.Leechers, t.Downloads})
+		}
+		return out
+	}
+
+	tests := []struct {
+		name string
+		got  []*trackerTier
+		want []counts
+	}{
+		{
+			name: "tiers",
+			got:  trackerTiers(stats),
+			want: []counts{
+				{"tracker.example.org:443", 0, 12, 3, 100},
+				{"backup.example.net:6969", 1, 4, 1, 7},
+				{"tracker.example.org:443", 1, -1, -1, -1},
+			},
+		},
+		{
+			name: "hosts",
+			got:  trackerHosts(stats),
+			want: []counts{
+				{"backup.example.net:6969", 0, 4, 1, 7},
+				{"tracker.example.org:443", 0, 12, 3, 100},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := countsOf(tt.got)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d trackers %v, want %d", len(got), got, len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("tracker %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
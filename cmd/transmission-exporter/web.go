@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"golang.org/x/crypto/bcrypt"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// webConfigReloadInterval is how often the web config file is checked for changes
+	webConfigReloadInterval = 5 * time.Second
+	// webReadHeaderTimeout limits the time to read request headers against slowloris attacks
+	webReadHeaderTimeout = 10 * time.Second
+	// unknownUserHash is compared against for unknown basic auth users
+	unknownUserHash = "$2a$10$6iL.jy.5mk6HtkNbMazeo.8yW1j6mdOS8SBYSNi98Ra/M.xd.XwoC"
+)
+
+// WebServerConfig is the content of the web config file
+// securing the exporter's own endpoints
+type WebServerConfig struct {
+	TLSServerConfig *TLSServerConfig `yaml:"tls_server_config"`
+	// BasicAuthUsers maps usernames to bcrypt hashed passwords
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+}
+
+// TLSServerConfig enables TLS and optionally client certificate authentication
+type TLSServerConfig struct {
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	ClientCAFile   string `yaml:"client_ca_file"`
+	ClientAuthType string `yaml:"client_auth_type"`
+	MinVersion     string `yaml:"min_version"`
+}
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// webServer serves the exporter's endpoints with TLS and basic auth
+// of the web config file, which is reloaded once it changes
+type webServer struct {
+	logger log.Logger
+	path   string
+
+	mu          sync.RWMutex
+	config      WebServerConfig
+	tls         *tls.Config
+	fingerprint [sha256.Size]byte
+	// authenticated caches successful logins, as bcrypt is slow on purpose
+	authenticated map[[sha256.Size]byte]bool
+}
+
+// newWebServer loads the web config file at path,
+// an empty path serves plain HTTP without authentication
+func newWebServer(logger log.Logger, path string) (*webServer, error) {
+	s := &webServer{logger: logger, path: path}
+	if path == "" {
+		return s, nil
+	}
+
+	if _, err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the web config file and the files it references
+// and applies them if anything changed. It returns true if it did.
+func (s *webServer) load() (bool, error) {
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return false, err
+	}
+
+	var config WebServerConfig
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return false, err
+	}
+
+	files := [][]byte{content}
+
+	for user, hash := range config.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return false, fmt.Errorf("invalid bcrypt hash for user %q: %v", user, err)
+		}
+	}
+
+	var tlsConfig *tls.Config
+	if config.TLSServerConfig != nil {
+		var tlsFiles [][]byte
+		tlsConfig, tlsFiles, err = config.TLSServerConfig.load()
+		if err != nil {
+			return false, err
+		}
+		files = append(files, tlsFiles...)
+	}
+
+	fingerprint := sha256.Sum256(bytes.Join(files, []byte{0}))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if fingerprint == s.fingerprint {
+		return false, nil
+	}
+
+	s.config = config
+	s.tls = tlsConfig
+	s.fingerprint = fingerprint
+	s.authenticated = make(map[[sha256.Size]byte]bool)
+
+	return true, nil
+}
+
+// load builds the tls.Config and returns the content of the files it read
+func (c *TLSServerConfig) load() (*tls.Config, [][]byte, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, nil, fmt.Errorf("tls_server_config needs cert_file and key_file")
+	}
+
+	certPEM, err := ioutil.ReadFile(c.CertFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(c.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate or key: %v", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	files := [][]byte{certPEM, keyPEM}
+
+	if c.MinVersion != "" {
+		version, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown min_version %q", c.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if c.ClientCAFile != "" {
+		caPEM, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, nil, fmt.Errorf("no certificates found in client_ca_file %s", c.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		files = append(files, caPEM)
+	}
+
+	if c.ClientAuthType != "" {
+		authType, ok := clientAuthTypes[c.ClientAuthType]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown client_auth_type %q", c.ClientAuthType)
+		}
+		if authType >= tls.VerifyClientCertIfGiven && config.ClientCAs == nil {
+			return nil, nil, fmt.Errorf("client_auth_type %s needs a client_ca_file", c.ClientAuthType)
+		}
+		config.ClientAuth = authType
+	}
+
+	return config, files, nil
+}
+
+// watch reloads the web config file on changes until stop is closed,
+// invalid changes are logged and the previous config is kept
+func (s *webServer) watch(stop <-chan struct{}) {
+	if s.path == "" {
+		return
+	}
+
+	ticker := time.NewTicker(webConfigReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		tlsEnabled := s.tlsConfig() != nil
+
+		changed, err := s.load()
+		if err != nil {
+			level.Error(s.logger).Log("msg", "failed to reload web config file, keeping the previous one", "err", err)
+			continue
+		}
+		if changed {
+			level.Info(s.logger).Log("msg", "reloaded web config file")
+			if tlsEnabled != (s.tlsConfig() != nil) {
+				level.Warn(s.logger).Log("msg", "enabling or disabling TLS only takes effect after a restart")
+			}
+		}
+	}
+}
+
+func (s *webServer) tlsConfig() *tls.Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tls
+}
+
+// getConfigForClient returns the current TLS config for every handshake
+func (s *webServer) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	config := s.tlsConfig()
+	if config == nil {
+		return nil, fmt.Errorf("TLS got disabled in the web config file")
+	}
+	return config, nil
+}
+
+// authenticate returns true if the web config file has no basic auth users
+// or the request's credentials match one of them
+func (s *webServer) authenticate(r *http.Request) bool {
+	s.mu.RLock()
+	users := s.config.BasicAuthUsers
+	s.mu.RUnlock()
+
+	if len(users) == 0 {
+		return true
+	}
+
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	hash, known := users[user]
+	if !known {
+		// Compare anyway, so unknown users can't be told apart by timing
+		hash = unknownUserHash
+	}
+
+	key := sha256.Sum256([]byte(user + "\x00" + password + "\x00" + hash))
+	s.mu.RLock()
+	cached := s.authenticated[key]
+	s.mu.RUnlock()
+	if cached {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil || !known {
+		return false
+	}
+
+	s.mu.Lock()
+	s.authenticated[key] = true
+	s.mu.Unlock()
+
+	return true
+}
+
+// unauthenticatedPaths don't require basic auth, so orchestrators can probe them
+var unauthenticatedPaths = map[string]bool{
+	"/-/healthy": true,
+	"/-/ready":   true,
+}
+
+// Handler requires basic auth for the next handler if it's configured
+func (s *webServer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !unauthenticatedPaths[r.URL.Path] && !s.authenticate(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="transmission-exporter"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Server returns an http.Server serving the handler with basic auth on addr
+func (s *webServer) Server(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(handler),
+		ReadHeaderTimeout: webReadHeaderTimeout,
+	}
+}
+
+// ListenAndServe runs the server on its address,
+// with TLS if it's enabled in the web config file
+func (s *webServer) ListenAndServe(server *http.Server) error {
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(server, ln)
+}
+
+// Serve runs the server on ln, with TLS if it's enabled in the web config file
+func (s *webServer) Serve(server *http.Server, ln net.Listener) error {
+	if s.tlsConfig() == nil {
+		return server.Serve(ln)
+	}
+
+	server.TLSConfig = &tls.Config{
+		GetConfigForClient: s.getConfigForClient,
+		// Only needed for ServeTLS to accept empty file names,
+		// the certificate is taken from the config of getConfigForClient
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			config, err := s.getConfigForClient(hello)
+			if err != nil {
+				return nil, err
+			}
+			return &config.Certificates[0], nil
+		},
+	}
+	return server.ServeTLS(ln, "", "")
+}
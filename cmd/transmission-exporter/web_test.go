@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	stdlog "log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testCA issues certificates for the TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM encoded certificate and key for a server on localhost or a client
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, content []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func bcryptHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+// authStatus returns the status code of a request with the credentials to the web server's handler
+func authStatus(s *webServer, path, user, password string) int {
+	r := httptest.NewRequest("GET", path, nil)
+	if user != "" || password != "" {
+		r.SetBasicAuth(user, password)
+	}
+	rec := httptest.NewRecorder()
+	s.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, r)
+	return rec.Code
+}
+
+func TestWebServerBasicAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "web.yml")
+	writeFile(t, path, []byte(fmt.Sprintf("basic_auth_users:\n  alice: %s\n", bcryptHash(t, "secret"))))
+
+	s, err := newWebServer(log.NewNopLogger(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		user     string
+		password string
+		want     int
+	}{
+		{name: "valid", path: "/metrics", user: "alice", password: "secret", want: http.StatusOK},
+		{name: "cached", path: "/metrics", user: "alice", password: "secret", want: http.StatusOK},
+		{name: "wrong password", path: "/metrics", user: "alice", password: "wrong", want: http.StatusUnauthorized},
+		{name: "unknown user", path: "/metrics", user: "bob", password: "secret", want: http.StatusUnauthorized},
+		{name: "missing authorization header", path: "/metrics", want: http.StatusUnauthorized},
+		{name: "probe", path: "/probe", want: http.StatusUnauthorized},
+		{name: "healthy", path: "/-/healthy", want: http.StatusOK},
+		{name: "ready", path: "/-/ready", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := authStatus(s, tt.path, tt.user, tt.password); got != tt.want {
+				t.Errorf("got status %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebServerWithoutConfig(t *testing.T) {
+	s, err := newWebServer(log.NewNopLogger(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := authStatus(s, "/metrics", "", ""); got != http.StatusOK {
+		t.Errorf("got status %d without web config file, want %d", got, http.StatusOK)
+	}
+	if s.Server(":19091", http.NotFoundHandler()).ReadHeaderTimeout == 0 {
+		t.Error("the server has no ReadHeaderTimeout")
+	}
+}
+
+func TestWebServerReloadUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "web.yml")
+	writeFile(t, path, []byte(fmt.Sprintf("basic_auth_users:\n  alice: %s\n", bcryptHash(t, "secret"))))
+
+	s, err := newWebServer(log.NewNopLogger(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := authStatus(s, "/metrics", "alice", "secret"); got != http.StatusOK {
+		t.Fatalf("got status %d for alice, want %d", got, http.StatusOK)
+	}
+
+	// Unchanged files aren't applied again
+	if changed, err := s.load(); err != nil || changed {
+		t.Errorf("load() = %v, %v without changes, want false, nil", changed, err)
+	}
+
+	writeFile(t, path, []byte(fmt.Sprintf("basic_auth_users:\n  bob: %s\n", bcryptHash(t, "secret"))))
+	if changed, err := s.load(); err != nil || !changed {
+		t.Fatalf("load() = %v, %v, want true, nil", changed, err)
+	}
+	// The cached login of alice is dropped with the removed user
+	if got := authStatus(s, "/metrics", "alice", "secret"); got != http.StatusUnauthorized {
+		t.Errorf("got status %d for removed alice, want %d", got, http.StatusUnauthorized)
+	}
+	if got := authStatus(s, "/metrics", "bob", "secret"); got != http.StatusOK {
+		t.Errorf("got status %d for added bob, want %d", got, http.StatusOK)
+	}
+
+	// A broken config is rejected and bob can still log in
+	for _, content := range []string{
+		"basic_auth_users: [",
+		"basic_auth_users:\n  bob: not-a-bcrypt-hash\n",
+		"unknown_key: true\n",
+	} {
+		writeFile(t, path, []byte(content))
+		if _, err := s.load(); err == nil {
+			t.Errorf("load() of %q didn't fail", content)
+		}
+		if got := authStatus(s, "/metrics", "bob", "secret"); got != http.StatusOK {
+			t.Errorf("got status %d for bob after a broken reload, want %d", got, http.StatusOK)
+		}
+	}
+}
+
+// serveTLS serves the web server on a random port of localhost and returns its address
+func serveTLS(t *testing.T, s *webServer) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := s.Server(ln.Addr().String(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	// Rejected handshakes are expected
+	server.ErrorLog = stdlog.New(io.Discard, "", 0)
+	go s.Serve(server, ln)
+	t.Cleanup(func() { server.Close() })
+
+	return ln.Addr().String()
+}
+
+// tlsGet requests the address on a new connection and returns the server certificate's common name
+func tlsGet(addr string, config *tls.Config) (string, error) {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}}
+	res, err := client.Get("https://" + addr + "/metrics")
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("got status %d", res.StatusCode)
+	}
+	return res.TLS.PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestWebServerTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+
+	certFile, keyFile, caFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt")
+	cert, key := ca.issue(t, "first", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, cert)
+	writeFile(t, keyFile, key)
+	writeFile(t, caFile, ca.pem)
+
+	path := filepath.Join(dir, "web.yml")
+	writeFile(t, path, []byte(fmt.Sprintf("tls_server_config:\n  cert_file: %s\n  key_file: %s\n  client_ca_file: %s\n", certFile, keyFile, caFile)))
+
+	s, err := newWebServer(log.NewNopLogger(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveTLS(t, s)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientCert, clientKey := ca.issue(t, "client", x509.ExtKeyUsageClientAuth)
+	pair, err := tls.X509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withCert := &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{pair}}
+
+	// client_ca_file requires a client certificate
+	if _, err := tlsGet(addr, &tls.Config{RootCAs: roots}); err == nil {
+		t.Error("request without client certificate succeeded")
+	}
+	if name, err := tlsGet(addr, withCert); err != nil || name != "first" {
+		t.Fatalf("got certificate %q, %v, want first", name, err)
+	}
+
+	// A new certificate is served on new connections after the reload
+	cert, key = ca.issue(t, "second", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, cert)
+	writeFile(t, keyFile, key)
+	if changed, err := s.load(); err != nil || !changed {
+		t.Fatalf("load() = %v, %v, want true, nil", changed, err)
+	}
+	if name, err := tlsGet(addr, withCert); err != nil || name != "second" {
+		t.Errorf("got certificate %q, %v after the reload, want second", name, err)
+	}
+
+	// A broken certificate is rejected and the previous one is kept
+	writeFile(t, keyFile, []byte("broken"))
+	if _, err := s.load(); err == nil {
+		t.Error("load() of a broken key didn't fail")
+	}
+	if name, err := tlsGet(addr, withCert); err != nil || name != "second" {
+		t.Errorf("got certificate %q, %v after a broken reload, want second", name, err)
+	}
+}
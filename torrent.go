@@ -51,6 +51,15 @@ type (
 		PeersGettingFromUs int           `json:"peersGettingFromUs"`
 		TotalSize          int           `json:"totalSize"`
 		UploadedEver       int           `json:"uploadedEver"`
+		PieceCount         int           `json:"pieceCount"`
+		PieceSize          int64         `json:"pieceSize"`
+		Pieces             string        `json:"pieces"`
+		Availability       []int         `json:"availability"`
+		DesiredAvailable   int64         `json:"desiredAvailable"`
+		HaveValid          int64         `json:"haveValid"`
+		HaveUnchecked      int64         `json:"haveUnchecked"`
+		CorruptEver        int64         `json:"corruptEver"`
+		Labels             []string      `json:"labels"`
 	}
 
 	// ByID implements the sort Interface to sort by ID
@@ -2,11 +2,14 @@ package transmission
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 )
 
 const endpoint = "/transmission/rpc/"
@@ -19,11 +22,31 @@ type (
 	}
 	// Client connects to transmission via HTTP
 	Client struct {
-		URL   string
-		token string
+		URL string
+
+		token   string
+		tokenMu sync.Mutex
 
 		User   *User
+		userMu sync.RWMutex
 		client http.Client
+
+		// OnRequest is called after every RPC request if set
+		OnRequest func(RequestInfo)
+		// RefreshUser is called if Transmission rejects the User, e.g. to
+		// re-read rotated credentials. The request is retried if it changed.
+		RefreshUser func() (*User, error)
+		// PieceFields requests the pieces bitfield and availability of every torrent,
+		// which grow with the number of pieces and are only needed for piece metrics
+		PieceFields bool
+	}
+	// RequestInfo describes a finished RPC request
+	RequestInfo struct {
+		Method       string
+		Duration     time.Duration
+		StatusCode   int
+		ResponseSize int
+		Err          error
 	}
 )
 
@@ -35,8 +58,29 @@ func New(url string, user *User) *Client {
 	}
 }
 
-func (c *Client) post(body []byte) ([]byte, error) {
-	authRequest, err := c.authRequest("POST", body)
+func (c *Client) post(ctx context.Context, method string, body []byte) ([]byte, error) {
+	start := time.Now()
+	info := RequestInfo{Method: method}
+
+	resBody, err := c.do(ctx, body, &info)
+	if info.StatusCode == http.StatusUnauthorized && c.refreshUser() {
+		resBody, err = c.do(ctx, body, &info)
+	}
+
+	if c.OnRequest != nil {
+		info.Duration = time.Since(start)
+		info.ResponseSize = len(resBody)
+		info.Err = err
+		c.OnRequest(info)
+	}
+
+	return resBody, err
+}
+
+func (c *Client) do(ctx context.Context, body []byte, info *RequestInfo) ([]byte, error) {
+	info.StatusCode = 0
+
+	authRequest, err := c.authRequest(ctx, "POST", body)
 	if err != nil {
 		return make([]byte, 0), err
 	}
@@ -46,14 +90,11 @@ func (c *Client) post(body []byte) ([]byte, error) {
 		return make([]byte, 0), err
 	}
 	defer res.Body.Close()
-
-	if res.StatusCode == http.StatusUnauthorized {
-		return make([]byte, 0), errors.New("authorization failed, check your username and password and make sure the ip is whitelisted")
-	}
+	info.StatusCode = res.StatusCode
 
 	if res.StatusCode == http.StatusConflict {
-		c.getToken()
-		authRequest, err := c.authRequest("POST", body)
+		c.getToken(ctx)
+		authRequest, err := c.authRequest(ctx, "POST", body)
 		if err != nil {
 			return make([]byte, 0), err
 		}
@@ -61,6 +102,12 @@ func (c *Client) post(body []byte) ([]byte, error) {
 		if err != nil {
 			return make([]byte, 0), err
 		}
+		defer res.Body.Close()
+		info.StatusCode = res.StatusCode
+	}
+
+	if res.StatusCode == http.StatusUnauthorized {
+		return make([]byte, 0), errors.New("authorization failed, check your username and password and make sure the ip is whitelisted")
 	}
 
 	resBody, err := ioutil.ReadAll(res.Body)
@@ -70,78 +117,133 @@ func (c *Client) post(body []byte) ([]byte, error) {
 	return resBody, nil
 }
 
-func (c *Client) getToken() error {
-	req, err := http.NewRequest("POST", c.URL, strings.NewReader(""))
+// refreshUser calls RefreshUser and returns true if the user changed
+func (c *Client) refreshUser() bool {
+	if c.RefreshUser == nil {
+		return false
+	}
+
+	user, err := c.RefreshUser()
 	if err != nil {
-		return err
+		return false
+	}
+
+	c.userMu.Lock()
+	defer c.userMu.Unlock()
+
+	if user == c.User || (user != nil && c.User != nil && *user == *c.User) {
+		return false
 	}
+	c.User = user
+
+	return true
+}
+
+func (c *Client) setBasicAuth(req *http.Request) {
+	c.userMu.RLock()
+	defer c.userMu.RUnlock()
 
 	if c.User != nil {
 		req.SetBasicAuth(c.User.Username, c.User.Password)
 	}
+}
+
+func (c *Client) getToken(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.URL, strings.NewReader(""))
+	if err != nil {
+		return err
+	}
+
+	c.setBasicAuth(req)
 
 	res, err := c.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
+
+	c.tokenMu.Lock()
 	c.token = res.Header.Get("X-Transmission-Session-Id")
+	c.tokenMu.Unlock()
+
 	return nil
 }
 
-func (c *Client) authRequest(method string, body []byte) (*http.Request, error) {
-	if c.token == "" {
-		err := c.getToken()
+func (c *Client) sessionToken() string {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	return c.token
+}
+
+func (c *Client) authRequest(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	token := c.sessionToken()
+	if token == "" {
+		err := c.getToken(ctx)
 		if err != nil {
 			return nil, err
 		}
+		token = c.sessionToken()
 	}
-	req, err := http.NewRequest(method, c.URL, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, method, c.URL, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("X-Transmission-Session-Id", c.token)
+	req.Header.Add("X-Transmission-Session-Id", token)
 
-	if c.User != nil {
-		req.SetBasicAuth(c.User.Username, c.User.Password)
-	}
+	c.setBasicAuth(req)
 
 	return req, nil
 }
 
 // GetTorrents get a list of torrents
 func (c *Client) GetTorrents() ([]Torrent, error) {
+	return c.GetTorrentsContext(context.Background())
+}
+
+// GetTorrentsContext gets a list of torrents, canceling the request with ctx
+func (c *Client) GetTorrentsContext(ctx context.Context) ([]Torrent, error) {
+	fields := []string{
+		"id",
+		"name",
+		"hashString",
+		"status",
+		"addedDate",
+		"leftUntilDone",
+		"eta",
+		"uploadRatio",
+		"rateDownload",
+		"rateUpload",
+		"downloadDir",
+		"isFinished",
+		"percentDone",
+		"seedRatioMode",
+		"error",
+		"errorString",
+		"files",
+		"fileStats",
+		"peers",
+		"trackers",
+		"trackerStats",
+		"peersConnected",
+		"peersGettingFromUs",
+		"totalSize",
+		"uploadedEver",
+		"pieceCount",
+		"pieceSize",
+		"desiredAvailable",
+		"haveValid",
+		"haveUnchecked",
+		"corruptEver",
+		"labels",
+	}
+	if c.PieceFields {
+		fields = append(fields, "pieces", "availability")
+	}
+
 	cmd := TorrentCommand{
-		Method: "torrent-get",
-		Arguments: TorrentArguments{
-			Fields: []string{
-				"id",
-				"name",
-				"hashString",
-				"status",
-				"addedDate",
-				"leftUntilDone",
-				"eta",
-				"uploadRatio",
-				"rateDownload",
-				"rateUpload",
-				"downloadDir",
-				"isFinished",
-				"percentDone",
-				"seedRatioMode",
-				"error",
-				"errorString",
-				"files",
-				"fileStats",
-				"peers",
-				"trackers",
-				"trackerStats",
-				"peersConnected",
-				"peersGettingFromUs",
-				"totalSize",
-				"uploadedEver",
-			},
-		},
+		Method:    "torrent-get",
+		Arguments: TorrentArguments{Fields: fields},
 	}
 
 	req, err := json.Marshal(&cmd)
@@ -149,7 +251,7 @@ func (c *Client) GetTorrents() ([]Torrent, error) {
 		return nil, err
 	}
 
-	resp, err := c.post(req)
+	resp, err := c.post(ctx, cmd.Method, req)
 	if err != nil {
 		return nil, err
 	}
@@ -164,12 +266,17 @@ func (c *Client) GetTorrents() ([]Torrent, error) {
 
 // GetSession gets the current session from transmission
 func (c *Client) GetSession() (*Session, error) {
+	return c.GetSessionContext(context.Background())
+}
+
+// GetSessionContext gets the current session, canceling the request with ctx
+func (c *Client) GetSessionContext(ctx context.Context) (*Session, error) {
 	req, err := json.Marshal(SessionCommand{Method: "session-get"})
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := c.post(req)
+	resp, err := c.post(ctx, "session-get", req)
 	if err != nil {
 		return nil, err
 	}
@@ -184,12 +291,17 @@ func (c *Client) GetSession() (*Session, error) {
 
 // GetSessionStats gets stats on the current & cumulative session
 func (c *Client) GetSessionStats() (*SessionStats, error) {
+	return c.GetSessionStatsContext(context.Background())
+}
+
+// GetSessionStatsContext gets the session stats, canceling the request with ctx
+func (c *Client) GetSessionStatsContext(ctx context.Context) (*SessionStats, error) {
 	req, err := json.Marshal(SessionCommand{Method: "session-stats"})
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := c.post(req)
+	resp, err := c.post(ctx, "session-stats", req)
 	if err != nil {
 		return nil, err
 	}
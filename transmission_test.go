@@ -0,0 +1,78 @@
+package transmission
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sessionServer answers session-get like Transmission,
+// rejecting requests without the current session id with 409
+func sessionServer(t *testing.T, sessionID string) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Transmission-Session-Id") != sessionID {
+			w.Header().Set("X-Transmission-Session-Id", sessionID)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.Write([]byte(`{"arguments":{"version":"4.0.5"},"result":"success"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestClientConcurrentToken(t *testing.T) {
+	srv := sessionServer(t, "token")
+	client := New(srv.URL, nil)
+
+	// Run with -race, every request fetches the token concurrently first
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session, err := client.GetSession()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if session.Version != "4.0.5" {
+				t.Errorf("got version %q, want 4.0.5", session.Version)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClientContext(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	client := New(srv.URL, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.GetSessionContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want the context's deadline exceeded", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("request returned after %v, not canceled with the context", d)
+	}
+}